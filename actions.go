@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/extemporalgenome/slug"
+)
+
+// ActionFunc is run with the ids of the rows selected in the list view.
+type ActionFunc func(ids []string) error
+
+// action is a bulk action that can be run from the list view.
+type action struct {
+	Name string
+	Slug string
+	fn   ActionFunc
+}
+
+// deleteAction is always available to users with permission to delete. It is handled separately, as it asks for
+// confirmation and runs in a single transaction.
+var deleteAction = &action{Name: "Delete selected", Slug: "delete"}
+
+// RegisterAction adds a bulk action to the model's list view, like "Publish" or "Archive". Users need permission to
+// change the model to run it.
+func (m *model) RegisterAction(name string, fn ActionFunc) error {
+	actionSlug := slug.SlugAscii(name)
+	if m.action(actionSlug) != nil {
+		return errors.New(fmt.Sprintf("An action with the name %v already exists.", name))
+	}
+
+	m.actions = append(m.actions, &action{name, actionSlug, fn})
+	return nil
+}
+
+func (m *model) action(actionSlug string) *action {
+	if actionSlug == deleteAction.Slug {
+		return deleteAction
+	}
+	for _, a := range m.actions {
+		if a.Slug == actionSlug {
+			return a
+		}
+	}
+	return nil
+}
+
+// allowedActions returns the actions a user with the given permissions can run.
+func (m *model) allowedActions(perm Permission) []*action {
+	actions := []*action{}
+	if perm.CanChange() {
+		actions = append(actions, m.actions...)
+	}
+	if perm.CanDelete() {
+		actions = append(actions, deleteAction)
+	}
+	return actions
+}
+
+// deleteMany deletes all rows in ids in one transaction, so either all or none of them are deleted.
+func (m *model) deleteMany(username string, ids []string) error {
+	tx, err := m.admin.begin()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = m.deleteTx(tx, username, id)
+		if err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+	return tx.commit()
+}
+
+// runAction runs a custom action, and records it in the audit log for each row, in one transaction.
+func (m *model) runAction(username string, act *action, ids []string) error {
+	err := act.fn(ids)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.admin.begin()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = m.log(tx, username, id, act.Slug, []*fieldChange{})
+		if err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+	return tx.commit()
+}
@@ -1,7 +1,32 @@
 package admin
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"html/template"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/oal/admin/db"
+	"github.com/oal/admin/fields"
 )
 
 func TestParseTagSimple(T *testing.T) {
@@ -41,3 +66,1540 @@ func TestParseTagMulti(T *testing.T) {
 		T.Error("Expected 'and' to be found.")
 	}
 }
+
+func TestStaticAuth(T *testing.T) {
+	auth := &staticAuth{"admin", "secret"}
+	if ok, _ := auth.Authenticate("admin", "secret"); !ok {
+		T.Error("Expected correct username and password to be accepted")
+	}
+	if ok, _ := auth.Authenticate("admin", "wrong"); ok {
+		T.Error("Expected wrong password to be rejected")
+	}
+	if ok, _ := auth.Authenticate("", ""); ok {
+		T.Error("Expected empty username and password to be rejected")
+	}
+}
+
+func TestMemorySessionStore(T *testing.T) {
+	store := NewMemorySessionStore(time.Hour, 0)
+	store.Save(&Session{Key: "fresh", Created: time.Now(), LastSeen: time.Now()})
+	store.Save(&Session{Key: "idle", Created: time.Now(), LastSeen: time.Now().Add(-2 * time.Hour)})
+
+	if sess, _ := store.Get("fresh"); sess == nil {
+		T.Error("Expected 'fresh' session to be found")
+	}
+	if sess, _ := store.Get("idle"); sess != nil {
+		T.Error("Expected 'idle' session to have expired")
+	}
+
+	store.Delete("fresh")
+	if sess, _ := store.Get("fresh"); sess != nil {
+		T.Error("Expected 'fresh' session to be deleted")
+	}
+}
+
+func TestGrants(T *testing.T) {
+	grants := NewGrants()
+	grants.GrantGroup("editor", "blog", PermView|PermChange)
+	grants.GrantModel("publisher", "blog-post", PermAdd|PermDelete)
+	grants.Assign("alice", "editor", "publisher")
+	grants.Assign("bob", "editor")
+
+	perm, _ := grants.Permissions("alice", "blog", "blog-post")
+	if perm != PermAll {
+		T.Error("Expected alice to have all permissions on blog-post")
+	}
+
+	perm, _ = grants.Permissions("bob", "blog", "blog-post")
+	if !perm.CanView() || !perm.CanChange() || perm.CanAdd() || perm.CanDelete() {
+		T.Error("Expected bob to only view and change blog-post")
+	}
+
+	perm, _ = grants.Permissions("bob", "shop", "product")
+	if perm != 0 {
+		T.Error("Expected bob to have no permissions outside the blog group")
+	}
+}
+
+type searchTestModel struct {
+	Id    int
+	Title string `admin:"list search"`
+	Body  string `admin:"search"`
+	Views int    `admin:"list"`
+}
+
+func TestSearchWhere(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	mdl, err := group.registerModel(new(searchTestModel))
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	where, args := mdl.searchCond(`go "100%`)
+	if strings.Contains(where, "go") || strings.Contains(where, "100") {
+		T.Error("Expected search terms to be passed as arguments, got", where)
+	}
+	if strings.Count(where, "?") != 4 || len(args) != 4 {
+		T.Error("Expected one placeholder and argument per term and searchable field, got", where, args)
+	}
+	if args[2] != `%"100!%%` {
+		T.Error("Expected LIKE wildcards in terms to be escaped, got", args[2])
+	}
+
+	if where, args := mdl.searchCond("   "); where != "" || args != nil {
+		T.Error("Expected empty search to give no condition")
+	}
+}
+
+func TestDialects(T *testing.T) {
+	pg := db.PostgresDialect{}
+	if q := pg.Queryf("SELECT * FROM %v WHERE a = ?%v", pg.Quote("Post"), " AND b = ?"); q != `SELECT * FROM "Post" WHERE a = $1 AND b = $2` {
+		T.Error("Expected numbered placeholders, got", q)
+	}
+	if q := pg.Paginate(25, 50); q != "LIMIT 25 OFFSET 50" {
+		T.Error("Expected LIMIT / OFFSET, got", q)
+	}
+
+	mysql := db.MySQLDialect{}
+	if q := mysql.Quote("Post`s"); q != "`Post``s`" {
+		T.Error("Expected backtick quoting, got", q)
+	}
+	if q := mysql.StringAgg("name", ","); q != "GROUP_CONCAT(name SEPARATOR ',')" {
+		T.Error("Expected GROUP_CONCAT with SEPARATOR, got", q)
+	}
+	if t := pg.ColumnType(db.PrimaryKey, 0); t != "SERIAL PRIMARY KEY" {
+		T.Error("Expected SERIAL primary key, got", t)
+	}
+	if t := mysql.ColumnType(db.Text, 100); t != "VARCHAR(100)" {
+		T.Error("Expected VARCHAR for text with a max length, got", t)
+	}
+	if t := (db.SQLiteDialect{}).Time(`"Post"."Added"`); t != `datetime("Post"."Added")` || pg.Time("Added") != "Added" {
+		T.Error("Expected SQLite to compare times with datetime, got", t)
+	}
+}
+
+func TestAPIFormValue(T *testing.T) {
+	tests := map[string]interface{}{
+		"":      nil,
+		"text":  "text",
+		"42":    float64(42),
+		"1.5":   1.5,
+		"true":  true,
+		"1,2,3": []interface{}{float64(1), float64(2), float64(3)},
+	}
+	for expected, val := range tests {
+		if got := apiFormValue(val); got != expected {
+			T.Errorf("Expected %#v to be %q, got %q", val, expected, got)
+		}
+	}
+}
+
+// plainField is a custom field without BaseField's methods.
+type plainField struct {
+	base fields.BaseField
+}
+
+func (f *plainField) Configure(map[string]string) error           { return nil }
+func (f *plainField) Render(io.Writer, interface{}, string, bool) {}
+func (f *plainField) RenderString(val interface{}) template.HTML  { return "" }
+func (f *plainField) Validate(val string) (interface{}, error)    { return val, nil }
+func (f *plainField) Attrs() *fields.BaseField                    { return &f.base }
+
+func TestBooleanField(T *testing.T) {
+	field := &fields.BooleanField{BaseField: &fields.BaseField{}}
+	for _, val := range []interface{}{int64(1), true} {
+		if !strings.Contains(string(field.RenderString(val)), "glyphicon-ok") || field.RenderText(val) != "true" {
+			T.Errorf("Expected %#v to be shown as true", val)
+		}
+	}
+	for _, val := range []interface{}{int64(0), false, nil} {
+		if !strings.Contains(string(field.RenderString(val)), "glyphicon-remove") || field.RenderText(val) != "false" {
+			T.Errorf("Expected %#v to be shown as false", val)
+		}
+	}
+}
+
+func TestCSVEscape(T *testing.T) {
+	for val, expected := range map[string]string{"=1+1": "'=1+1", "@SUM(A1)": "'@SUM(A1)", "-5": "-5", "+a": "'+a", "Hello": "Hello", "": ""} {
+		if escaped := csvEscape(val); escaped != expected || csvUnescape(escaped) != val {
+			T.Errorf("Expected %q to be exported as %q, got %q", val, expected, escaped)
+		}
+	}
+
+	if fields.RenderText(&plainField{}, 5) != "5" {
+		T.Error("Expected fields without RenderText to be rendered like BaseField")
+	}
+	timeField := &fields.TimeField{BaseField: &fields.BaseField{}, Format: "2006-01-02"}
+	if fields.RenderText(timeField, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) != "2020-01-02" {
+		T.Error("Expected the field's own RenderText to be used")
+	}
+}
+
+func TestParseImport(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	mdl, err := group.registerModel(new(searchTestModel))
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	rows, unknown, err := mdl.parseImport("rows.csv", strings.NewReader("\ufefftitle,Views,Other\nHello,3,x\n"))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Get("Title") != "Hello" || rows[0].Get("Views") != "3" {
+		T.Error("Expected CSV columns to be matched to fields, got", rows)
+	}
+	if len(unknown) != 1 || unknown[0] != "Other" {
+		T.Error("Expected unknown columns to be reported, got", unknown)
+	}
+
+	rows, _, err = mdl.parseImport("rows.json", strings.NewReader(`[{"Title": "Hello", "Views": 3}]`))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Get("Views") != "3" {
+		T.Error("Expected JSON values to be converted to form values, got", rows)
+	}
+}
+
+type importCountry struct {
+	Code string `admin:"pk"`
+	Name string
+}
+
+func TestImportTransaction(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	// An in-memory database only exists on one connection, which the import's transaction holds
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE importCountry (Code TEXT PRIMARY KEY, Name TEXT)`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err = a.setupLog(); err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	mdl, err := group.registerModel(new(importCountry))
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// The second row sees the first one, and updates it
+	rows := []url.Values{{"Code": {"NO"}, "Name": {"Norge"}}, {"Code": {"NO"}, "Name": {"Norway"}}}
+	results, ok, err := mdl.runImport("admin", rows, PermAll, true)
+	if err != nil || !ok {
+		T.Fatal("Expected the import to succeed, got", err, results[0].Errors, results[1].Errors)
+	}
+	var name string
+	err = a.db.QueryRow(`SELECT Name FROM importCountry WHERE Code = 'NO'`).Scan(&name)
+	if err != nil || name != "Norway" {
+		T.Error("Expected the row to be added and then updated, got", name, err)
+	}
+}
+
+type inlineParent struct {
+	Id   int
+	Name string
+}
+
+type inlineChild struct {
+	Id     int
+	Parent *inlineParent
+	Name   string
+}
+
+func TestInlinesOnlyFromForm(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE inlineParent (Id INTEGER PRIMARY KEY, Name TEXT);
+		CREATE TABLE inlineChild (Id INTEGER PRIMARY KEY, ParentId INTEGER, Name TEXT);
+		INSERT INTO inlineParent VALUES (1, 'Parent');
+		INSERT INTO inlineChild VALUES (1, 1, 'Child');`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err = a.setupLog(); err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	group.RegisterModel(new(inlineParent))
+	group.RegisterModel(new(inlineChild))
+	if a.Model(new(searchTestModel)) != nil {
+		T.Error("Expected no model for unregistered types")
+	}
+	parent, child := a.Model(new(inlineParent)), a.Model(new(inlineChild))
+	if err = parent.RegisterInline(child); err != nil {
+		T.Fatal(err)
+	}
+
+	// Inline rows that would delete the existing child and add another one
+	inlineKeys := map[string]string{
+		child.Slug + "-count":    "2",
+		child.Slug + "-0-Id":     "1",
+		child.Slug + "-0-DELETE": "true",
+		child.Slug + "-1-Name":   "New",
+	}
+
+	body := `{"Name": "Changed"`
+	for key, val := range inlineKeys {
+		body += fmt.Sprintf(`, %q: %q`, key, val)
+	}
+	req := httptest.NewRequest("PUT", "/admin/api/inlineparent/1/", strings.NewReader(body+"}"))
+	req = req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, &Session{Username: "admin"}))
+	rw := httptest.NewRecorder()
+	a.handleAPISave(rw, req, httprouter.Params{{Key: "slug", Value: parent.Slug}, {Key: "id", Value: "1"}})
+	if rw.Code != 200 {
+		T.Fatal("Expected the API to save the parent, got", rw.Code, rw.Body.String())
+	}
+
+	row := url.Values{"Id": {"1"}, "Name": {"Imported"}}
+	for key, val := range inlineKeys {
+		row.Set(key, val)
+	}
+	_, ok, err := parent.runImport("admin", []url.Values{row}, PermAll, true)
+	if !ok || err != nil {
+		T.Fatal("Expected the import to save the parent, got", err)
+	}
+
+	var names string
+	err = a.db.QueryRow(`SELECT group_concat(Name) FROM inlineChild`).Scan(&names)
+	if err != nil || names != "Child" {
+		T.Error("Expected inline rows from the API and imports to be ignored, got", names, err)
+	}
+
+	// From the form, added rows are only saved once they've been changed
+	form := url.Values{"Name": {"Parent"}, child.Slug + "-count": {"2"}, child.Slug + "-0-Name": {"Default"},
+		child.Slug + "-1-Name": {"Typed"}, child.Slug + "-1-CHANGED": {"true"}}
+	_, _, _, err = parent.save("admin", "1", &http.Request{Form: form}, parent.inlines)
+	if err != nil {
+		T.Fatal(err)
+	}
+	err = a.db.QueryRow(`SELECT group_concat(Name) FROM inlineChild`).Scan(&names)
+	if err != nil || names != "Child,Typed" {
+		T.Error("Expected only the changed row to be added, got", names, err)
+	}
+}
+
+func TestPrefixedField(T *testing.T) {
+	field := &fields.TextField{BaseField: &fields.BaseField{Name: "Title"}}
+	prefixed := prefixedField(field, "post-0-")
+	if prefixed.Attrs().Name != "post-0-Title" {
+		T.Error("Expected prefixed name, got", prefixed.Attrs().Name)
+	}
+	if field.Attrs().Name != "Title" {
+		T.Error("Expected original field to be unchanged, got", field.Attrs().Name)
+	}
+
+	// Fields built on other fields have their BaseField further down
+	image := makeField(reflect.String, "image").(*fields.ImageField)
+	image.Attrs().Name = "Photo"
+	prefixed = prefixedField(image, "post-0-")
+	if prefixed.Attrs().Name != "post-0-Photo" || image.Attrs().Name != "Photo" {
+		T.Error("Expected only the copy to be prefixed, got", prefixed.Attrs().Name, image.Attrs().Name)
+	}
+}
+
+func TestFileFieldIgnoresText(T *testing.T) {
+	field := makeField(reflect.String, "file")
+	field.Attrs().Name = "File"
+	field.Attrs().Blank = true
+	req := &http.Request{Form: url.Values{"File": {"app.db"}}}
+
+	val, err := fields.Validate(field, req, nil)
+	if err != nil || val != "" {
+		T.Error("Expected posted names to be ignored for new rows, got", val, err)
+	}
+	val, err = fields.Validate(field, req, "report.pdf")
+	if err != nil || val != "report.pdf" {
+		T.Error("Expected the existing file to be kept, got", val, err)
+	}
+}
+
+// uploadedFile returns the header of a file uploaded with a multipart form.
+func uploadedFile(T *testing.T, filename string, data []byte) *multipart.FileHeader {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, _ := w.CreateFormFile("file", filename)
+	part.Write(data)
+	w.Close()
+	form, err := multipart.NewReader(&buf, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		T.Fatal(err)
+	}
+	return form.File["file"][0]
+}
+
+func pngImage(width, height int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+	return buf.Bytes()
+}
+
+func TestImageField(T *testing.T) {
+	storage := &fields.MemoryStorage{}
+	field := makeField(reflect.String, "image").(*fields.ImageField)
+	err := field.Configure(map[string]string{"min_width": "20", "max_height": "100", "thumb_width": "10"})
+	if err != nil {
+		T.Fatal(err)
+	}
+	field.Storage = storage
+
+	// A PNG header claiming a huge image, without the pixels
+	huge := pngImage(1, 1)
+	binary.BigEndian.PutUint32(huge[16:], 50000)
+	binary.BigEndian.PutUint32(huge[20:], 50000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	for data, expected := range map[string]string{
+		string(pngImage(10, 10)):  "at least 20 pixels wide",
+		string(pngImage(20, 200)): "more than 100 pixels high",
+		string(huge):              "too large",
+	} {
+		if _, err := field.HandleFile(uploadedFile(T, "a.png", []byte(data))); err == nil || !strings.Contains(err.Error(), expected) {
+			T.Errorf("Expected %q, got %v", expected, err)
+		}
+	}
+
+	name, err := field.HandleFile(uploadedFile(T, "my photo.png", pngImage(40, 20)))
+	if err != nil || name != "my_photo.png" {
+		T.Fatal("Expected the image to be stored, got", name, err)
+	}
+	thumb, _ := storage.Get("_my_photo.thumb.png")
+	config, err := png.DecodeConfig(bytes.NewReader(thumb))
+	if err != nil || config.Width != 10 || config.Height != 5 {
+		T.Error("Expected a 10x5 thumbnail, got", config, err)
+	}
+
+	// Uploads can't take a thumbnail's name
+	if name, err = field.HandleFile(uploadedFile(T, "_my_photo.thumb.png", pngImage(40, 20))); err != nil || name != "my_photo.thumb.png" {
+		T.Error("Expected the underscore to be removed, got", name, err)
+	}
+
+	// If a thumbnail's name is taken anyway, the image gets another name, and the existing file is left alone
+	storage.Save("_taken.thumb.png", strings.NewReader("other"))
+	name, err = field.HandleFile(uploadedFile(T, "taken.png", pngImage(40, 20)))
+	if err != nil || name != "taken-1.png" {
+		T.Fatal("Expected the image to be stored under another name, got", name, err)
+	}
+	if _, ok := storage.Get("_taken-1.thumb.png"); !ok {
+		T.Error("Expected a thumbnail for the new name")
+	}
+	if data, _ := storage.Get("_taken.thumb.png"); string(data) != "other" {
+		T.Error("Expected the existing file to be kept")
+	}
+	for _, name := range []string{"taken.png", "_taken.thumb-1.png"} {
+		if _, ok := storage.Get(name); ok {
+			T.Error("Expected the file to be removed again:", name)
+		}
+	}
+}
+
+func TestFileFieldLimits(T *testing.T) {
+	field := makeField(reflect.String, "file").(*fields.FileField)
+	if err := field.Configure(map[string]string{"max_size": "lots"}); err == nil {
+		T.Error("Expected an invalid max_size to be refused")
+	}
+	err := field.Configure(map[string]string{"max_size": "1 kb", "accept": ".pdf, image/*"})
+	if err != nil || field.MaxSize != 1024 {
+		T.Fatal("Expected max_size to be parsed, got", field.MaxSize, err)
+	}
+	field.Storage = &fields.MemoryStorage{}
+
+	for filename, data := range map[string]string{"a.pdf": "%PDF-1.4", "a.png": string(pngImage(1, 1))} {
+		if _, err := field.HandleFile(uploadedFile(T, filename, []byte(data))); err != nil {
+			T.Errorf("Expected %v to be accepted, got %v", filename, err)
+		}
+	}
+	for filename, data := range map[string]string{"a.txt": "text", "b.pdf": strings.Repeat("x", 2000)} {
+		if _, err := field.HandleFile(uploadedFile(T, filename, []byte(data))); err == nil {
+			T.Errorf("Expected %v to be refused", filename)
+		}
+	}
+	if fields.FormatSize(2<<20) != "2 MB" || fields.FormatSize(1536) != "1.5 KB" {
+		T.Error("Expected sizes to be formatted with units, got", fields.FormatSize(2<<20), fields.FormatSize(1536))
+	}
+}
+
+func TestMaxRequestSize(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.User("admin", "pw")
+	a.MaxRequestSize = 100
+	group, _ := a.Group("Test")
+	mdl, _ := group.registerModel(new(searchTestModel))
+
+	// Forms are sent back where they came from, with a message
+	sess := &Session{Username: "admin", Messages: []*FlashMessage{}}
+	req := httptest.NewRequest("POST", "/admin/create/x/", strings.NewReader("Title="+strings.Repeat("x", 200)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", "http://example.com/admin/new/x/")
+	req = req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, sess))
+	rw := httptest.NewRecorder()
+	if a.parseForm(rw, req) || rw.Code != 302 || rw.Header().Get("Location") != "/admin/new/x/" || len(sess.Messages) != 1 {
+		T.Error("Expected the form to be sent back with a message, got", rw.Code, rw.Header().Get("Location"))
+	}
+
+	// The JSON API returns an error
+	req = httptest.NewRequest("POST", "/admin/api/x/", strings.NewReader(`{"Title": "`+strings.Repeat("x", 200)+`"}`))
+	req.SetBasicAuth("admin", "pw")
+	req.Header.Set("Content-Type", "application/json")
+	rw = httptest.NewRecorder()
+	a.apiWrapper(a.handleAPISave, PermAdd)(rw, req, httprouter.Params{{Key: "slug", Value: mdl.Slug}})
+	if rw.Code != 413 || !strings.Contains(rw.Body.String(), "100 bytes") {
+		T.Error("Expected API requests to be limited too, got", rw.Code, rw.Body.String())
+	}
+}
+
+func TestAPIBasicAuthContentType(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	a.User("admin", "pw")
+	_, err = a.db.Exec(`CREATE TABLE lookupTag (Id INTEGER PRIMARY KEY, Name TEXT, Slug TEXT)`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err := a.setupLog(); err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	mdl, _ := group.registerModel(new(lookupTag))
+
+	// Forms on other sites can send these, along with credentials the browser remembers
+	for contentType, code := range map[string]int{
+		"application/x-www-form-urlencoded": 415,
+		"multipart/form-data; boundary=x":   415,
+		"text/plain":                        415,
+		"":                                  415,
+		"application/json; charset=utf-8":   201,
+	} {
+		req := httptest.NewRequest("POST", "/admin/api/x/", strings.NewReader(`{"Name": "go", "Slug": "go"}`))
+		req.SetBasicAuth("admin", "pw")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rw := httptest.NewRecorder()
+		a.apiWrapper(a.handleAPISave, PermAdd)(rw, req, httprouter.Params{{Key: "slug", Value: mdl.Slug}})
+		if rw.Code != code {
+			T.Errorf("Expected %v for %q, got %v: %v", code, contentType, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+type logPost struct {
+	Id        int
+	Title     string     `admin:"list"`
+	Draft     bool       `admin:"filter"`
+	Published time.Time  `admin:"filter"`
+	Author    *lookupTag `admin:"filter"`
+}
+
+// newLogTest sets up an admin with logPost and lookupTag tables, and an audit log.
+func newLogTest(T *testing.T) (*Admin, *Model) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE lookupTag (Id INTEGER PRIMARY KEY, Name TEXT, Slug TEXT);
+		CREATE TABLE logPost (id INTEGER PRIMARY KEY, Title TEXT, Draft BOOLEAN, Published DATETIME, AuthorId INTEGER);
+		INSERT INTO lookupTag VALUES (1, 'go', 'g'), (2, 'sql', 's');`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err := a.setupLog(); err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	group.RegisterModel(new(lookupTag))
+	group.RegisterModel(new(logPost))
+	return a, a.Model(new(logPost))
+}
+
+func TestSaveUnchanged(T *testing.T) {
+	_, mdl := newLogTest(T)
+
+	form := url.Values{"Title": {"Hello"}, "Draft": {"true"}, "Published": {"2020-01-02 15:04"}, "AuthorId": {"1"}}
+	id, _, _, err := mdl.save("alice", "", &http.Request{Form: form}, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// Values are validated as other types than the database returns, but they're the same
+	_, _, _, err = mdl.save("alice", id, &http.Request{Form: form}, nil)
+	if _, ok := err.(noChangesError); !ok {
+		T.Error("Expected no changes, got", err)
+	}
+	entries, err := mdl.history(id)
+	if err != nil || len(entries) != 1 {
+		T.Error("Expected a single log entry, got", len(entries), err)
+	}
+
+	form.Set("AuthorId", "2")
+	if _, _, _, err = mdl.save("alice", id, &http.Request{Form: form}, nil); err != nil {
+		T.Fatal(err)
+	}
+	entries, _ = mdl.history(id)
+	if len(entries) != 2 || len(entries[0].Changes) != 1 || entries[0].Changes[0].Field != "AuthorId" {
+		T.Error("Expected only the author to be changed, got", entries[0].Changes)
+	}
+}
+
+func TestAuditLog(T *testing.T) {
+	a, mdl := newLogTest(T)
+	if err := a.setupLog(); err != nil {
+		T.Error("Expected the log table to be set up again without errors, got", err)
+	}
+
+	form := url.Values{"Title": {"Hello"}, "Published": {"2020-01-02 15:04"}, "AuthorId": {"1"}}
+	id, _, _, err := mdl.save("alice", "", &http.Request{Form: form}, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	form.Set("Title", "Bye")
+	if _, _, _, err = mdl.save("bob", id, &http.Request{Form: form}, nil); err != nil {
+		T.Fatal(err)
+	}
+	if err = mdl.delete("carol", id); err != nil {
+		T.Fatal(err)
+	}
+
+	// Entries for other models with the same id aren't part of the history
+	tags := a.Model(new(lookupTag))
+	if err = tags.log(a.db, "dave", id, logUpdate, []*fieldChange{}); err != nil {
+		T.Fatal(err)
+	}
+
+	entries, err := mdl.history(id)
+	if err != nil {
+		T.Fatal(err)
+	}
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Username+":"+entry.Action)
+		if entry.Model != mdl.Slug || entry.ObjectId != id || entry.Time.IsZero() {
+			T.Error("Expected the entry to be for the post, got", entry)
+		}
+	}
+	if strings.Join(actions, " ") != "carol:delete bob:update alice:create" {
+		T.Fatal("Expected the post's history newest first, got", actions)
+	}
+
+	changes := map[string]*fieldChange{}
+	for _, change := range entries[2].Changes {
+		changes[change.Field] = change
+		if change.Before != nil {
+			T.Error("Expected no values before the post was created, got", change)
+		}
+	}
+	if changes["Title"] == nil || changes["Title"].After != "Hello" || changes["Title"].Label != "Title" {
+		T.Error("Expected the created title to be logged, got", changes["Title"])
+	}
+	if len(entries[1].Changes) != 1 || entries[1].Changes[0].Before != "Hello" || entries[1].Changes[0].After != "Bye" {
+		T.Error("Expected only the title to be changed, got", entries[1].Changes)
+	}
+	for _, change := range entries[0].Changes {
+		if change.After != nil || (change.Field == "Title" && change.Before != "Bye") {
+			T.Error("Expected the deleted post's values to be logged, got", change)
+		}
+	}
+
+	recent, err := a.recentActions(2)
+	if err != nil || len(recent) != 2 || recent[0].Username != "dave" || recent[1].Username != "carol" {
+		T.Error("Expected the latest entries for all models, got", recent, err)
+	}
+}
+
+func TestFilters(T *testing.T) {
+	// Dates are entered in the admin's time zone, but stored as UTC
+	local := time.Local
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+	defer func() { time.Local = local }()
+
+	_, mdl := newLogTest(T)
+	today := time.Now().Format("2006-01-02")
+	for _, form := range []url.Values{
+		{"Title": {"a"}, "Draft": {"true"}, "Published": {"2020-01-01 23:30"}, "AuthorId": {"1"}},
+		{"Title": {"b"}, "Published": {"2020-01-02 00:30"}, "AuthorId": {"2"}},
+		{"Title": {"c"}, "Published": {"2020-01-02 23:59"}, "AuthorId": {"1"}},
+		{"Title": {"d"}, "Published": {today + " 12:00"}, "AuthorId": {"2"}},
+	} {
+		if _, _, _, err := mdl.save("alice", "", &http.Request{Form: form}, nil); err != nil {
+			T.Fatal(err)
+		}
+	}
+
+	// Written by something else, in another time zone. It's 2020-01-01 22:00 in UTC.
+	_, err := mdl.admin.db.Exec(`INSERT INTO logPost (Title, Draft, Published, AuthorId) VALUES ('e', 0, '2020-01-02 03:00:00+05:00', 1)`)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	for query, expected := range map[string]string{
+		"filter.Draft=true":  "a",
+		"filter.Draft=false": "b c d e",
+		"filter.Draft=maybe": "a b c d e",
+		"filter.AuthorId=2":  "b d",
+		"filter.AuthorId=":   "a b c d e",
+		"filter.Published=custom&filter.Published.from=2020-01-02&filter.Published.to=2020-01-02": "b c",
+		"filter.Published=custom&filter.Published.from=2020-01-02":                                "b c d",
+		"filter.Published=custom&filter.Published.to=2020-01-01":                                  "a e",
+		"filter.Published=custom&filter.Published.from=yesterday":                                 "a b c d e",
+		"filter.Published=today":                                      "d",
+		"filter.Published=someday":                                    "a b c d e",
+		"filter.Draft=false&filter.AuthorId=1":                        "c e",
+		"filter.Draft=false&filter.Published=today&filter.AuthorId=1": "",
+	} {
+		values, _ := url.ParseQuery(query)
+		results, count, err := mdl.page(1, "", values, "Title", false)
+		if err != nil {
+			T.Fatal(err)
+		}
+		titles := []string{}
+		for _, row := range results {
+			titles = append(titles, fmt.Sprint(row[1]))
+		}
+		if strings.Join(titles, " ") != expected || count != len(titles) {
+			T.Errorf("Expected %q for %v, got %q (%v rows)", expected, query, strings.Join(titles, " "), count)
+		}
+	}
+
+	// The sidebar links keep other filters, and mark the current ones
+	values, _ := url.ParseQuery("filter.Draft=true&q=x&page=2")
+	views, err := mdl.filterViews(values)
+	if err != nil || len(views) != 3 {
+		T.Fatal("Expected a filter for each field, got", len(views), err)
+	}
+	options := []string{}
+	for _, option := range views[0].Options {
+		options = append(options, fmt.Sprintf("%v %v %v", option.Label, option.Active, option.URL))
+	}
+	if strings.Join(options, ", ") != "All false ?q=x, Yes true ?filter.Draft=true&q=x, No false ?filter.Draft=false&q=x" {
+		T.Error("Expected yes / no options, got", options)
+	}
+	options = []string{}
+	for _, option := range views[2].Options {
+		options = append(options, option.Label)
+	}
+	if strings.Join(options, " ") != "All 1 2" || !views[1].Range || views[1].Hidden.Get("filter.Published") != "custom" {
+		T.Error("Expected related rows and a date range, got", options, views[1])
+	}
+}
+
+type hookPost struct {
+	Id        int
+	Title     string `admin:"blank list"`
+	Draft     bool
+	Published time.Time
+	Author    *lookupTag   `admin:"blank null"`
+	Tags      []*lookupTag `admin:"blank"`
+}
+
+var hookCalls []string
+
+func (p *hookPost) BeforeSave() map[string]string {
+	hookCalls = append(hookCalls, fmt.Sprintf("before save %v", p.Id))
+	if p.Title == "" && !p.Draft {
+		return map[string]string{"Title": "Published posts need a title."}
+	}
+	if p.Author != nil && p.Author.Id == 2 && len(p.Tags) == 1 && p.Published.Year() == 2020 {
+		p.Title = strings.ToUpper(p.Title)
+		p.Tags = append(p.Tags, &lookupTag{Id: 1})
+	}
+	return nil
+}
+
+func (p *hookPost) AfterSave() {
+	hookCalls = append(hookCalls, fmt.Sprintf("after save %v %v", p.Id, p.Title))
+}
+
+func (p *hookPost) BeforeDelete() error {
+	hookCalls = append(hookCalls, fmt.Sprintf("before delete %v", p.Id))
+	if p.Draft {
+		return errors.New("Drafts can't be deleted.")
+	}
+	return nil
+}
+
+func (p *hookPost) AfterDelete() {
+	hookCalls = append(hookCalls, fmt.Sprintf("after delete %v %v", p.Id, p.Title))
+}
+
+// newHookTest sets up an admin with hookPost and lookupTag tables, and an audit log.
+func newHookTest(T *testing.T) *Model {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE lookupTag (Id INTEGER PRIMARY KEY, Name TEXT, Slug TEXT);
+		CREATE TABLE hookPost (id INTEGER PRIMARY KEY, Title TEXT, Draft BOOLEAN, Published DATETIME, AuthorId INTEGER);
+		CREATE TABLE hookPost_Tags (hookPost_id INTEGER, lookupTag_id INTEGER);
+		INSERT INTO lookupTag VALUES (1, 'go', 'g'), (2, 'sql', 's');`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err := a.setupLog(); err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	group.RegisterModel(new(lookupTag))
+	group.RegisterModel(new(hookPost))
+	if err := a.Check(); err != nil {
+		T.Fatal(err)
+	}
+	hookCalls = nil
+	return a.Model(new(hookPost))
+}
+
+func TestHooksGetStruct(T *testing.T) {
+	mdl := newHookTest(T)
+
+	// The hook sees typed values, and its changes are saved
+	form := url.Values{"Title": {"hello"}, "Published": {"2020-01-02 15:04"}, "AuthorId": {"2"}, "Tags": {"2"}}
+	id, _, _, err := mdl.save("alice", "", &http.Request{Form: form}, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	obj, err := mdl.Get(id)
+	if err != nil {
+		T.Fatal(err)
+	}
+	post := obj.(*hookPost)
+	if post.Title != "HELLO" || len(post.Tags) != 2 {
+		T.Error("Expected the hook's changes to be saved, got", post.Title, len(post.Tags))
+	}
+
+	form = url.Values{"Title": {""}, "Published": {"2020-01-02 15:04"}}
+	_, _, dataErrors, err := mdl.save("alice", "", &http.Request{Form: form}, nil)
+	if err == nil || dataErrors["Title"] != "Published posts need a title." {
+		T.Error("Expected the hook's error to be shown, got", dataErrors, err)
+	}
+}
+
+func TestHooks(T *testing.T) {
+	mdl := newHookTest(T)
+
+	form := url.Values{"Title": {"hello"}, "Published": {"2020-01-02 15:04"}, "AuthorId": {"2"}, "Tags": {"2"}}
+	id, _, _, err := mdl.save("alice", "", &http.Request{Form: form}, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	form = url.Values{"Title": {"draft"}, "Draft": {"true"}, "Published": {"2020-01-02 15:04"}}
+	draftId, _, _, err := mdl.save("alice", "", &http.Request{Form: form}, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// The BeforeSave change is stored
+	var title string
+	if err := mdl.admin.db.QueryRow("SELECT Title FROM hookPost WHERE id = ?", id).Scan(&title); err != nil || title != "HELLO" {
+		T.Error("Expected the title changed by BeforeSave to be stored, got", title, err)
+	}
+
+	// A BeforeDelete error keeps the row
+	if err := mdl.delete("alice", draftId); err == nil || err.Error() != "Drafts can't be deleted." {
+		T.Error("Expected the draft not to be deleted, got", err)
+	}
+	if _, err := mdl.get(mdl.admin.db, draftId); err != nil {
+		T.Error("Expected the draft to be kept, got", err)
+	}
+	if err := mdl.delete("alice", id); err != nil {
+		T.Fatal(err)
+	}
+
+	expected := []string{
+		"before save 0", "after save " + id + " HELLO",
+		"before save 0", "after save " + draftId + " draft",
+		"before delete " + draftId,
+		"before delete " + id, "after delete " + id + " HELLO",
+	}
+	if strings.Join(hookCalls, ", ") != strings.Join(expected, ", ") {
+		T.Errorf("Expected hooks to be called with the right rows, got %v", hookCalls)
+	}
+}
+
+type importPost struct {
+	Id     int
+	Title  string       `admin:"blank"`
+	Author *lookupTag   `admin:"blank null list='Name'"`
+	Tags   []*lookupTag `admin:"blank list='Name'"`
+}
+
+func (p *importPost) BeforeSave() map[string]string {
+	hookCalls = append(hookCalls, fmt.Sprintf("before save %v", p.Id))
+	if p.Title == "" {
+		return map[string]string{"Title": "Posts need a title."}
+	}
+	return nil
+}
+
+func (p *importPost) AfterSave() {
+	hookCalls = append(hookCalls, fmt.Sprintf("after save %v %v", p.Id, p.Title))
+}
+
+func TestImportPreview(T *testing.T) {
+	a := newHookTest(T).admin
+	_, err := a.db.Exec(`CREATE TABLE importPost (id INTEGER PRIMARY KEY, Title TEXT, AuthorId INTEGER);
+		CREATE TABLE importPost_Tags (importPost_id INTEGER, lookupTag_id INTEGER);`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Other")
+	group.RegisterModel(new(importPost))
+	mdl := a.Model(new(importPost))
+
+	rows := []url.Values{
+		{"Title": {"a"}, "AuthorId": {"9"}},
+		{"Title": {"b"}, "AuthorId": {"sql"}},
+		{"Title": {"c"}, "Tags": {"1, 7"}},
+		{"Title": {""}},
+		{"Title": {"d"}, "AuthorId": {"1"}, "Tags": {"go"}},
+	}
+	results, ok, err := mdl.runImport("alice", rows, PermAll, false)
+	if err != nil || ok {
+		T.Fatal("Expected the preview to have errors, got", ok, err)
+	}
+	expected := []string{"AuthorId: 9 doesn't exist.", "", "Tags: 7 doesn't exist.", "Title: Posts need a title.", ""}
+	for i, row := range results {
+		if strings.Join(row.Errors, " ") != expected[i] {
+			T.Errorf("Expected %q for row %v, got %q", expected[i], i+1, row.Errors)
+		}
+	}
+
+	// Nothing is saved, and AfterSave hooks aren't called for a preview
+	var count int
+	if a.db.QueryRow("SELECT COUNT(*) FROM importPost").Scan(&count); count != 0 {
+		T.Error("Expected no rows after a preview, got", count)
+	}
+	if strings.Join(hookCalls, ", ") != "before save 0, before save 0, before save 0" {
+		T.Error("Expected only BeforeSave hooks for a preview, got", hookCalls)
+	}
+
+	hookCalls = nil
+	results, ok, err = mdl.runImport("alice", []url.Values{rows[1], rows[4]}, PermAll, true)
+	if err != nil || !ok {
+		T.Fatal("Expected the import to succeed, got", err, results[0].Errors, results[1].Errors)
+	}
+	if strings.Join(hookCalls, ", ") != "before save 0, before save 0, after save 1 b, after save 2 d" {
+		T.Error("Expected AfterSave hooks once the import is committed, got", hookCalls)
+	}
+	var author, tag int
+	if a.db.QueryRow("SELECT AuthorId FROM importPost WHERE id = 1").Scan(&author); author != 2 {
+		T.Error("Expected the author to be looked up by name, got", author)
+	}
+	if a.db.QueryRow("SELECT lookupTag_id FROM importPost_Tags WHERE importPost_id = 2").Scan(&tag); tag != 1 {
+		T.Error("Expected the tag to be looked up by name, got", tag)
+	}
+}
+
+type stringKeyPage struct {
+	Key   string
+	Title string `admin:"list"`
+}
+
+type pkCountry struct {
+	Name string `admin:"list"`
+	Code string `admin:"pk maxlength=2"`
+}
+
+func TestStringKeys(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE stringKeyPage (id TEXT PRIMARY KEY, Title TEXT);
+		CREATE TABLE pkCountry (Name TEXT, Code TEXT PRIMARY KEY);`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err := a.setupLog(); err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	group.RegisterModel(new(stringKeyPage))
+	group.RegisterModel(new(pkCountry))
+	if err := a.Check(); err != nil {
+		T.Fatal(err)
+	}
+
+	// Untagged keys are stored in the id column, and generated for new rows
+	pages := a.Model(new(stringKeyPage))
+	if pages.autoKey || pages.pkColumn() != "id" {
+		T.Fatal("Expected a string key in the id column, got", pages.autoKey, pages.pkColumn())
+	}
+	for _, form := range []url.Values{{"Title": {"a"}}, {"Key": {"z"}, "Title": {"b"}}} {
+		if _, _, _, err := pages.save("alice", "", &http.Request{Form: form}, nil); err != nil {
+			T.Fatal(err)
+		}
+	}
+	results, _, err := pages.page(1, "", nil, "Key", true)
+	if err != nil || len(results) != 2 {
+		T.Fatal("Expected pages to be sorted by their key, got", err)
+	}
+	if results[0][0] != "z" || len(fmt.Sprint(results[1][0])) != 36 {
+		T.Error("Expected the given key, and then a UUID, got", results[0][0], results[1][0])
+	}
+
+	// Tagged keys can be in any column, and are entered when adding rows
+	countries := a.Model(new(pkCountry))
+	if countries.autoKey || countries.fieldNames[0] != "Code" {
+		T.Fatal("Expected Code to be the key, got", countries.fieldNames)
+	}
+	id, _, _, err := countries.save("alice", "", &http.Request{Form: url.Values{"Code": {"NO"}, "Name": {"Norge"}}}, nil)
+	if err != nil || id != "NO" {
+		T.Fatal("Expected the row to be added with its key, got", id, err)
+	}
+	if _, _, _, err = countries.save("alice", "NO", &http.Request{Form: url.Values{"Code": {"SE"}, "Name": {"Norway"}}}, nil); err != nil {
+		T.Fatal(err)
+	}
+	data, err := countries.get(a.db, "NO")
+	if err != nil || data["Name"] != "Norway" || data["Code"] != "NO" {
+		T.Error("Expected the name to be changed, but not the key, got", data, err)
+	}
+	if _, _, err := countries.page(1, "", nil, "Code", true); err != nil {
+		T.Error("Expected countries to be sorted by their key, got", err)
+	}
+	if err = countries.delete("alice", "NO"); err != nil {
+		T.Fatal(err)
+	}
+	if _, err = countries.get(a.db, "NO"); err != sql.ErrNoRows {
+		T.Error("Expected the row to be deleted, got", err)
+	}
+}
+
+func TestActions(T *testing.T) {
+	mdl := newHookTest(T)
+	a := mdl.admin
+	wd, _ := os.Getwd()
+	if err := a.SourceDir(wd); err != nil {
+		T.Fatal(err)
+	}
+	if _, err := a.Handler(); err != nil {
+		T.Fatal(err)
+	}
+	published := []string{}
+	mdl.RegisterAction("Publish", func(ids []string) error {
+		published = append(published, ids...)
+		return nil
+	})
+
+	ids := []string{}
+	for _, form := range []url.Values{{"Title": {"first"}}, {"Title": {"second"}}, {"Title": {"draft"}, "Draft": {"true"}}} {
+		form.Set("Published", "2020-01-02 15:04")
+		id, _, _, err := mdl.save("alice", "", &http.Request{Form: form}, nil)
+		if err != nil {
+			T.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	sess := &Session{Username: "alice", Messages: []*FlashMessage{}}
+	run := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/action/"+mdl.Slug+"/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, sess))
+		rw := httptest.NewRecorder()
+		a.handleAction(rw, req, httprouter.Params{{Key: "slug", Value: mdl.Slug}})
+		return rw
+	}
+	lastMessage := func() string {
+		if len(sess.Messages) == 0 {
+			return ""
+		}
+		return sess.Messages[len(sess.Messages)-1].Text
+	}
+
+	if rw := run(url.Values{"action": {"nothing"}, "selected_id": ids}); rw.Code != 404 {
+		T.Error("Expected 404 for an unknown action, got", rw.Code)
+	}
+
+	// Custom actions are logged for each row
+	run(url.Values{"action": {"publish"}, "selected_id": ids[:2]})
+	if strings.Join(published, ",") != strings.Join(ids[:2], ",") || lastMessage() != "Publish was run on 2 hookPost." {
+		T.Error("Expected the action to be run on the selected rows, got", published, lastMessage())
+	}
+	if entries, err := mdl.history(ids[0]); err != nil || len(entries) != 2 || entries[0].Action != "publish" {
+		T.Error("Expected the action to be logged, got", entries, err)
+	}
+
+	// Deleting asks for confirmation first
+	rw := run(url.Values{"action": {"delete"}, "selected_id": ids[:2]})
+	body := rw.Body.String()
+	if rw.Code != 200 || !strings.Contains(body, "first") || !strings.Contains(body, "second") || !strings.Contains(body, `name="confirm" value="yes"`) {
+		T.Error("Expected the rows to be shown before deleting them, got", rw.Code, body)
+	}
+	if _, err := mdl.get(a.db, ids[0]); err != nil {
+		T.Error("Expected nothing to be deleted before confirming, got", err)
+	}
+
+	// Either all rows are deleted, or none of them
+	run(url.Values{"action": {"delete"}, "selected_id": ids, "confirm": {"yes"}})
+	if _, err := mdl.get(a.db, ids[0]); err != nil || lastMessage() != "Drafts can't be deleted." {
+		T.Error("Expected no rows to be deleted when one can't be, got", err, lastMessage())
+	}
+	run(url.Values{"action": {"delete"}, "selected_id": ids[:2], "confirm": {"yes"}})
+	for _, id := range ids[:2] {
+		if _, err := mdl.get(a.db, id); err != sql.ErrNoRows {
+			T.Error("Expected the row to be deleted, got", err)
+		}
+		if entries, _ := mdl.history(id); len(entries) == 0 || entries[0].Action != logDelete || entries[0].Username != "alice" {
+			T.Error("Expected the delete to be logged, got", entries)
+		}
+	}
+
+	// Errors when logging are returned
+	a.db.Exec("DROP TABLE " + logTable)
+	if err := mdl.runAction("alice", mdl.action("publish"), ids[2:]); err == nil {
+		T.Error("Expected the action to fail without an audit log")
+	}
+}
+
+type tagSet struct {
+	Id   int
+	Tags []*lookupTag `admin:"blank"`
+}
+
+func TestAPIErrors(T *testing.T) {
+	mdl := newHookTest(T)
+	a := mdl.admin
+	a.User("admin", "pw")
+	_, err := a.db.Exec(`CREATE TABLE tagSet (id INTEGER PRIMARY KEY);
+		CREATE TABLE tagSet_Tags (tagSet_id INTEGER, lookupTag_id INTEGER);`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Other")
+	group.RegisterModel(new(tagSet))
+	tagSets := a.Model(new(tagSet))
+
+	call := func(method string, handler httprouter.Handle, perm Permission, slug, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/api/x/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "pw")
+		rw := httptest.NewRecorder()
+		a.apiWrapper(handler, perm)(rw, req, httprouter.Params{{Key: "slug", Value: slug}, {Key: "id", Value: id}})
+		return rw
+	}
+
+	// Rows need at least one column
+	for _, body := range []string{`{}`, `{"Tags": [1]}`} {
+		if rw := call("POST", a.handleAPISave, PermAdd, tagSets.Slug, "", body); rw.Code != 400 || !strings.Contains(rw.Body.String(), "without any values") {
+			T.Error("Expected a 400 response without any columns, got", rw.Code, rw.Body.String())
+		}
+	}
+	var count int
+	if a.db.QueryRow("SELECT COUNT(*) FROM tagSet_Tags").Scan(&count); count != 0 {
+		T.Error("Expected no relations to be added, got", count)
+	}
+
+	for _, form := range []url.Values{{"Title": {"post"}}, {"Title": {"draft"}, "Draft": {"true"}}} {
+		form.Set("Published", "2020-01-02 15:04")
+		if _, _, _, err := mdl.save("alice", "", &http.Request{Form: form}, nil); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if rw := call("DELETE", a.handleAPIDelete, PermDelete, mdl.Slug, "9", ""); rw.Code != 404 {
+		T.Error("Expected 404 for a missing row, got", rw.Code, rw.Body.String())
+	}
+	if rw := call("DELETE", a.handleAPIDelete, PermDelete, mdl.Slug, "2", ""); rw.Code != 409 || !strings.Contains(rw.Body.String(), "Drafts can't be deleted.") {
+		T.Error("Expected 409 with the hook's reason, got", rw.Code, rw.Body.String())
+	}
+	a.db.Exec("DROP TABLE " + logTable)
+	if rw := call("DELETE", a.handleAPIDelete, PermDelete, mdl.Slug, "1", ""); rw.Code != 500 {
+		T.Error("Expected 500 when the database fails, got", rw.Code, rw.Body.String())
+	}
+}
+
+type lookupTag struct {
+	Id   int
+	Name string `admin:"list search"`
+	Slug string
+}
+
+func TestLookup(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE lookupTag (Id INTEGER PRIMARY KEY, Name TEXT, Slug TEXT);
+		INSERT INTO lookupTag VALUES (1, 'go', 'g'), (2, 'sql', 's'), (3, 'golang', 'gl');`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	group.RegisterModel(new(lookupTag))
+	slug := a.Model(new(lookupTag)).Slug
+
+	for query, expected := range map[string]string{
+		"q=go":                "1:go 3:golang",
+		"ids=3,%202,9":        "3:golang 2:sql",
+		"ids=1&display=Slug":  "1:g",
+		"ids=1&display=Nope":  "1:go",
+		"q=s&display=Slug":    "2:s",
+		"q=nothing+like+this": "",
+	} {
+		req := httptest.NewRequest("GET", "/admin/lookup/"+slug+"/?"+query, nil)
+		rw := httptest.NewRecorder()
+		a.handleLookup(rw, req, httprouter.Params{{Key: "slug", Value: slug}})
+
+		var body struct{ Results []*lookupResult }
+		json.NewDecoder(rw.Body).Decode(&body)
+		results := []string{}
+		for _, row := range body.Results {
+			results = append(results, row.Id+":"+row.Text)
+		}
+		if strings.Join(results, " ") != expected {
+			T.Errorf("Expected %q for %v, got %q", expected, query, strings.Join(results, " "))
+		}
+	}
+}
+
+func TestSyncSchemaStatements(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE lookupTag (Id INTEGER PRIMARY KEY, Name TEXT)`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	group.RegisterModel(new(lookupTag))
+
+	statements, err := a.SyncSchema()
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(statements) != 1 || !strings.HasPrefix(statements[0], "ALTER TABLE") || !strings.Contains(statements[0], "Slug") {
+		T.Errorf("Expected the Slug column to be added, got %v", statements)
+	}
+
+	statements, err = a.SyncSchema()
+	if err != nil || len(statements) != 0 {
+		T.Errorf("Expected nothing to be run on a synced schema, got %v (%v)", statements, err)
+	}
+}
+
+func TestCSRF(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	post := func(token, header string, cookies ...*http.Cookie) *http.Request {
+		req := httptest.NewRequest("POST", "/admin/save/x/1/", strings.NewReader(url.Values{csrfField: {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set(csrfHeader, header)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+	withSession := func(req *http.Request) *http.Request {
+		sess := &Session{Username: "admin", CSRFToken: "token", Messages: []*FlashMessage{}}
+		return req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, sess))
+	}
+
+	// Logged in users
+	for _, test := range []struct {
+		req      *http.Request
+		expected bool
+	}{
+		{withSession(post("", "")), false},
+		{withSession(post("wrong", "")), false},
+		{withSession(post("", "wrong")), false},
+		{withSession(post("token", "")), true},
+		{withSession(post("", "token")), true},
+	} {
+		if a.checkCSRF(test.req) != test.expected {
+			T.Errorf("Expected %v for token %q and header %q", test.expected, test.req.PostFormValue(csrfField), test.req.Header.Get(csrfHeader))
+		}
+	}
+
+	// Anonymous users get the token in a cookie, which is reused
+	rw := httptest.NewRecorder()
+	token := a.csrfToken(rw, httptest.NewRequest("GET", "/admin/login/", nil))
+	cookies := rw.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || cookies[0].Value != token || token == "" {
+		T.Fatal("Expected the token to be set in a cookie, got", cookies)
+	}
+	req := httptest.NewRequest("GET", "/admin/login/", nil)
+	req.AddCookie(cookies[0])
+	if a.csrfToken(httptest.NewRecorder(), req) != token {
+		T.Error("Expected the token in the cookie to be reused")
+	}
+
+	if !a.checkCSRF(post(token, "", cookies[0])) {
+		T.Error("Expected the token from the cookie to be accepted")
+	}
+	if a.checkCSRF(post(token, "")) {
+		T.Error("Expected the token to be rejected without the cookie")
+	}
+	if a.checkCSRF(post("", "", cookies[0])) || a.checkCSRF(post("wrong", "", cookies[0])) {
+		T.Error("Expected a missing or wrong token to be rejected")
+	}
+	if a.checkCSRF(post("", "", &http.Cookie{Name: csrfCookie, Value: ""})) {
+		T.Error("Expected an empty cookie and token to be rejected")
+	}
+}
+
+type m2mPost struct {
+	Id    int
+	Title string
+	Tags  []*lookupTag `admin:"blank rel_table='post_tags' rel_from='post' rel_to='tag' rel_order='position'"`
+}
+
+func TestSaveM2M(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE lookupTag (Id INTEGER PRIMARY KEY, Name TEXT, Slug TEXT);
+		CREATE TABLE m2mPost (Id INTEGER PRIMARY KEY, Title TEXT);
+		CREATE TABLE post_tags (post INTEGER, tag INTEGER, position INTEGER);
+		INSERT INTO lookupTag VALUES (1, 'go', 'g'), (2, 'sql', 's'), (3, 'golang', 'gl');`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err := a.setupLog(); err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	group.RegisterModel(new(lookupTag))
+	group.RegisterModel(new(m2mPost))
+	mdl := a.Model(new(m2mPost))
+	if err := a.Check(); err != nil {
+		T.Fatal(err)
+	}
+
+	post := &m2mPost{Title: "Hello"}
+	for _, ids := range [][]int{{3, 1}, {1, 2, 3}, {2}} {
+		post.Tags = []*lookupTag{}
+		for _, id := range ids {
+			post.Tags = append(post.Tags, &lookupTag{Id: id})
+		}
+		if err := mdl.Save(post, "admin"); err != nil {
+			T.Fatal(err)
+		}
+
+		// The join table has the related ids in the order they were saved, with nothing left over
+		rows, err := a.db.Query("SELECT tag, position FROM post_tags WHERE post = ? ORDER BY position", post.Id)
+		if err != nil {
+			T.Fatal(err)
+		}
+		saved := []int{}
+		for i := 0; rows.Next(); i++ {
+			var tag, position int
+			rows.Scan(&tag, &position)
+			if position != i {
+				T.Errorf("Expected position %v for tag %v, got %v", i, tag, position)
+			}
+			saved = append(saved, tag)
+		}
+		rows.Close()
+		if fmt.Sprint(saved) != fmt.Sprint(ids) {
+			T.Errorf("Expected tags %v to be saved, got %v", ids, saved)
+		}
+
+		obj, err := mdl.Get(post.Id)
+		if err != nil {
+			T.Fatal(err)
+		}
+		loaded := []int{}
+		for _, tag := range obj.(*m2mPost).Tags {
+			loaded = append(loaded, tag.Id)
+		}
+		if fmt.Sprint(loaded) != fmt.Sprint(ids) {
+			T.Errorf("Expected tags %v to be loaded, got %v", ids, loaded)
+		}
+	}
+}
+
+func TestSetStructField(T *testing.T) {
+	var obj struct {
+		Draft     bool
+		Views     int
+		Published time.Time
+		Parent    *struct{ Id int }
+	}
+	a := &Admin{registeredRels: map[reflect.Type]*model{}}
+	v := reflect.ValueOf(&obj).Elem()
+	timeField := &fields.TimeField{BaseField: &fields.BaseField{}, Format: "2006-01-02"}
+
+	for i, val := range []interface{}{int64(1), []byte("42"), "2020-01-02", int64(7)} {
+		var field fields.Field
+		if i == 2 {
+			field = timeField
+		}
+		err := a.setStructField(v.Field(i), field, val)
+		if err != nil {
+			T.Fatal(err)
+		}
+	}
+	if !obj.Draft || obj.Views != 42 || obj.Published.Year() != 2020 || obj.Parent == nil || obj.Parent.Id != 7 {
+		T.Error("Expected values to be converted to the struct's types, got", obj)
+	}
+	if a.structFormValue(v.Field(2)) != "2020-01-02T00:00:00Z" || a.structFormValue(v.Field(3)) != "7" {
+		T.Error("Expected struct fields to be converted back to form values")
+	}
+}
+
+type structEvent struct {
+	Id    int
+	Title string
+	Start time.Time `admin:"format='2006-01-02'"`
+}
+
+func TestSaveStruct(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE structEvent (Id INTEGER PRIMARY KEY, Title TEXT, Start DATETIME)`)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err := a.setupLog(); err != nil {
+		T.Fatal(err)
+	}
+	group, _ := a.Group("Test")
+	group.RegisterModel(new(structEvent))
+	mdl := a.Model(new(structEvent))
+
+	start := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	event := &structEvent{Title: "Launch", Start: start}
+	if err := mdl.Save(event, "alice"); err != nil {
+		T.Fatal(err)
+	}
+
+	// The field's format only has the date, but the time of day is kept
+	obj, err := mdl.Get(event.Id)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if saved := obj.(*structEvent).Start; !saved.Equal(start) {
+		T.Error("Expected the full time to be saved, got", saved)
+	}
+
+	var username string
+	err = a.db.QueryRow("SELECT username FROM "+logTable+" WHERE object_id = ?", fmt.Sprint(event.Id)).Scan(&username)
+	if err != nil || username != "alice" {
+		T.Error("Expected the change to be logged for alice, got", username, err)
+	}
+}
+
+type checkPage struct {
+	Id        int
+	Title     string
+	Published time.Time
+	Views     int
+}
+
+func TestCheck(T *testing.T) {
+	a, err := New("/admin", "sqlite3", ":memory:")
+	if err != nil {
+		T.Fatal(err)
+	}
+	a.db.SetMaxOpenConns(1)
+	_, err = a.db.Exec(`CREATE TABLE checkPage (id INTEGER PRIMARY KEY, Title TEXT, Published INTEGER)`)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	group, _ := a.Group("Pages")
+	group.registerModel(new(checkPage))
+
+	mismatches, ok := a.Check().(SchemaError)
+	if !ok || len(mismatches) != 2 {
+		T.Fatal("Expected wrong and missing columns to be reported, got", mismatches)
+	}
+	if !strings.Contains(mismatches[0], "Published") || !strings.Contains(mismatches[1], "Views") {
+		T.Error("Expected mismatches in field order, got", mismatches)
+	}
+}
+
+func TestLocalStorage(T *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := &fields.LocalStorage{Root: dir}
+	for _, expected := range []string{"posts/photo.jpg", "posts/photo-1.jpg"} {
+		name, err := storage.Save("posts/photo.jpg", strings.NewReader("data"))
+		if err != nil || name != expected {
+			T.Errorf("Expected %v, got %v (%v)", expected, name, err)
+		}
+	}
+	if _, err := storage.Save("../photo.jpg", strings.NewReader("data")); err == nil {
+		T.Error("Expected names outside of the root to be refused")
+	}
+	if err := storage.Delete("posts/photo.jpg"); err != nil {
+		T.Error(err)
+	}
+}
+
+func TestS3Storage(T *testing.T) {
+	// Stand-in for S3 that keeps objects in memory
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			rw.WriteHeader(403)
+			return
+		}
+		_, exists := objects[req.URL.Path]
+		switch {
+		case req.Method == "HEAD" && !exists, req.Method == "DELETE" && !exists:
+			rw.WriteHeader(404)
+		case req.Method == "PUT" && exists:
+			rw.WriteHeader(412)
+		case req.Method == "PUT":
+			objects[req.URL.Path], _ = ioutil.ReadAll(req.Body)
+		case req.Method == "DELETE":
+			delete(objects, req.URL.Path)
+			rw.WriteHeader(204)
+		}
+	}))
+	defer srv.Close()
+
+	storage := &fields.S3Storage{Endpoint: srv.URL, Region: "us-east-1", Bucket: "files", AccessKey: "key", SecretKey: "secret"}
+	for _, expected := range []string{"my photo.jpg", "my photo-1.jpg"} {
+		name, err := storage.Save("my photo.jpg", strings.NewReader("data"))
+		if err != nil || name != expected {
+			T.Errorf("Expected %v, got %v (%v)", expected, name, err)
+		}
+	}
+	if string(objects["/files/my photo-1.jpg"]) != "data" {
+		T.Errorf("Expected the file to be uploaded, got %v", objects)
+	}
+	if err := storage.Delete("my photo.jpg"); err != nil || len(objects) != 1 {
+		T.Error("Expected the file to be deleted", err)
+	}
+}
@@ -0,0 +1,290 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/oal/admin/fields"
+)
+
+// The JSON API mirrors the HTML admin, under /api/:slug/. Values are returned in the same format the API accepts them,
+// so an object can be changed and sent back as is.
+
+// apiWrapper is handlerWrapper for the JSON API. Requests are authenticated with the admin session cookie, or with
+// HTTP basic auth checked against Admin.Auth. Errors are returned as JSON instead of redirects.
+func (a *Admin) apiWrapper(h httprouter.Handle, perm Permission) httprouter.Handle {
+	return func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		req = a.withUserSession(req)
+		if a.getUserSession(req) != nil {
+			// Browsers send the cookie automatically, so changes need the CSRF token like the HTML admin
+			if req.Method != "GET" && !a.checkCSRF(req) {
+				writeJSONError(rw, 403, "Invalid or missing CSRF token.")
+				return
+			}
+		} else if username, password, ok := req.BasicAuth(); ok && a.Auth != nil {
+			valid, err := a.Auth.Authenticate(username, password)
+			if err != nil {
+				fmt.Println(err)
+			}
+			if valid {
+				// Browsers may send remembered credentials with forms on other sites, which can't send JSON
+				if (req.Method == "POST" || req.Method == "PUT") && !isJSON(req) {
+					writeJSONError(rw, 415, "The request must have the content type application/json.")
+					return
+				}
+
+				// Not stored, so it only lasts for this request
+				sess := &Session{Username: username, Messages: []*FlashMessage{}}
+				req = req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, sess))
+			}
+		}
+
+		if a.getUserSession(req) == nil {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			writeJSONError(rw, 401, "Authentication required.")
+			return
+		}
+		if a.MaxRequestSize > 0 {
+			req.Body = http.MaxBytesReader(rw, req.Body, a.MaxRequestSize)
+		}
+
+		model, ok := a.models[params.ByName("slug")]
+		if !ok {
+			writeJSONError(rw, 404, "Not found.")
+			return
+		}
+		if a.permissions(req, model)&perm != perm {
+			writeJSONError(rw, 403, "You don't have permission to do that.")
+			return
+		}
+		h(rw, req, params)
+	}
+}
+
+// isJSON checks if a request's body is JSON.
+func isJSON(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+func (a *Admin) handleAPIList(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	model := a.models[ps.ByName("slug")]
+
+	req.ParseForm()
+	q, sortBy, sortDesc, page := listParams(model, req)
+
+	results, rows, err := model.page(int(page), q, req.Form, sortBy, sortDesc)
+	if err != nil {
+		fmt.Println(err)
+		writeJSONError(rw, 500, "Could not load rows.")
+		return
+	}
+
+	// Only list fields are included, with related rows shown the same way as in the list view
+	objects := []map[string]interface{}{}
+	for _, row := range results {
+		obj := map[string]interface{}{}
+		for i, field := range model.listFields {
+			obj[field.Attrs().Name] = a.apiValue(field, row[i])
+		}
+		objects = append(objects, obj)
+	}
+
+	writeJSON(rw, 200, map[string]interface{}{
+		"count":   rows,
+		"page":    page,
+		"pages":   (rows + 24) / 25,
+		"results": objects,
+	})
+}
+
+func (a *Admin) handleAPIGet(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	model := a.models[ps.ByName("slug")]
+
+	data, err := model.get(a.db, ps.ByName("id"))
+	if err != nil {
+		writeJSONError(rw, 404, "Not found.")
+		return
+	}
+	writeJSON(rw, 200, model.apiObject(data))
+}
+
+// handleAPISave creates an object (POST) or updates one (PUT). Fields left out of an update keep their current value.
+func (a *Admin) handleAPISave(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	model := a.models[ps.ByName("slug")]
+
+	id := ps.ByName("id")
+	form := url.Values{}
+	if id != "" {
+		existing, err := model.get(a.db, id)
+		if err != nil {
+			writeJSONError(rw, 404, "Not found.")
+			return
+		}
+		form = model.formValues(existing)
+	}
+
+	body := map[string]interface{}{}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(rw, 413, fmt.Sprintf("The request can't be larger than %v.", fields.FormatSize(a.MaxRequestSize)))
+		return
+	} else if err != nil {
+		writeJSONError(rw, 400, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	for key, val := range body {
+		form.Set(key, apiFormValue(val))
+	}
+
+	// Validation and saving is done by model.save, just like for forms
+	req.Form = form
+	sess := a.getUserSession(req)
+	newId, _, dataErrors, err := model.save(sess.Username, id, req, nil)
+	if _, ok := err.(noChangesError); ok {
+		// Nothing to do, so just return the object as it is
+		err = nil
+	}
+	if err != nil {
+		if len(dataErrors) > 0 {
+			writeJSON(rw, 400, map[string]interface{}{
+				"error":  "Invalid data.",
+				"fields": dataErrors,
+			})
+		} else {
+			writeJSONError(rw, 400, err.Error())
+		}
+		return
+	}
+
+	data, err := model.get(a.db, newId)
+	if err != nil {
+		fmt.Println(err)
+		writeJSONError(rw, 500, "Could not load saved object.")
+		return
+	}
+
+	status := 200
+	if id == "" {
+		status = 201
+	}
+	writeJSON(rw, status, model.apiObject(data))
+}
+
+func (a *Admin) handleAPIDelete(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	model := a.models[ps.ByName("slug")]
+
+	sess := a.getUserSession(req)
+	err := model.delete(sess.Username, ps.ByName("id"))
+	if _, ok := err.(refusedError); ok {
+		writeJSONError(rw, 409, err.Error())
+		return
+	} else if err == sql.ErrNoRows {
+		writeJSONError(rw, 404, "Not found.")
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		writeJSONError(rw, 500, "Could not delete the object.")
+		return
+	}
+	rw.WriteHeader(204)
+}
+
+// apiObject converts the result of model.get to what the API returns.
+func (m *model) apiObject(data map[string]interface{}) map[string]interface{} {
+	obj := map[string]interface{}{}
+	for key, val := range data {
+		obj[key] = m.admin.apiValue(m.fieldByName(key), val)
+	}
+	return obj
+}
+
+// formValues converts the result of model.get to form values, so they can be changed and passed to model.save.
+func (m *model) formValues(data map[string]interface{}) url.Values {
+	form := url.Values{}
+	for key, val := range m.apiObject(data) {
+		form.Set(key, apiFormValue(val))
+	}
+	return form
+}
+
+// apiValue converts a value from the database to JSON friendly types.
+func (a *Admin) apiValue(field fields.Field, val interface{}) interface{} {
+	switch v := val.(type) {
+	case []string:
+		// Ids of related models with integer keys are numbers
+		m2mField, ok := field.(*fields.ManyToManyField)
+		if !ok || !a.relatedAutoKey(m2mField) || a.checkRelatedIds(m2mField, v) != nil {
+			break
+		}
+		ids := make([]int, len(v))
+		for i, id := range v {
+			ids[i], _ = strconv.Atoi(id)
+		}
+		return ids
+	case []byte:
+		return string(v)
+	case time.Time:
+		if timeField, ok := field.(*fields.TimeField); ok {
+			return v.Format(timeField.Format)
+		}
+	case int64:
+		if _, ok := field.(*fields.BooleanField); ok {
+			return v == 1
+		}
+	}
+	return val
+}
+
+// apiFormValue converts a JSON value to the string fields.Validate expects from a form.
+func apiFormValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []int:
+		ids := make([]string, len(v))
+		for i, id := range v {
+			ids[i] = strconv.Itoa(id)
+		}
+		return strings.Join(ids, ",")
+	case []string:
+		return strings.Join(v, ",")
+	case []interface{}:
+		ids := make([]string, len(v))
+		for i, id := range v {
+			ids[i] = apiFormValue(id)
+		}
+		return strings.Join(ids, ",")
+	}
+	return fmt.Sprint(val)
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	err := json.NewEncoder(rw).Encode(v)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func writeJSONError(rw http.ResponseWriter, status int, message string) {
+	writeJSON(rw, status, map[string]string{"error": message})
+}
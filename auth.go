@@ -1,61 +1,155 @@
 package admin
 
 import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
 	"net/http"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-func (a *Admin) getUserSession(req *http.Request) *session {
+// Authenticator checks the username and password entered on the login page. Set Admin.Auth to use a custom backend,
+// or use User / UserTable to set up one of the built-in ones.
+type Authenticator interface {
+	Authenticate(username, password string) (bool, error)
+}
+
+// AuthFunc allows an ordinary function to be used as an Authenticator.
+type AuthFunc func(username, password string) (bool, error)
+
+func (f AuthFunc) Authenticate(username, password string) (bool, error) {
+	return f(username, password)
+}
+
+// staticAuth is a single username / password pair, set with Admin.User.
+type staticAuth struct {
+	username string
+	password string
+}
+
+func (s *staticAuth) Authenticate(username, password string) (bool, error) {
+	userOk := subtle.ConstantTimeCompare([]byte(username), []byte(s.username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+	return userOk && passOk, nil
+}
+
+// tableAuth looks up users in a database table, with passwords stored as bcrypt hashes. Set up with Admin.UserTable.
+type tableAuth struct {
+	admin          *Admin
+	table          string
+	usernameColumn string
+	passwordColumn string
+}
+
+func (t *tableAuth) Authenticate(username, password string) (bool, error) {
+	q := t.admin.dialect.Queryf("SELECT %v FROM %v WHERE %v = ?", t.admin.quote(t.passwordColumn), t.admin.quote(t.table), t.admin.quote(t.usernameColumn))
+
+	var hash string
+	err := t.admin.db.QueryRow(q, username).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// HashPassword returns a bcrypt hash of password, suitable for storing in a table used with Admin.UserTable.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+type sessionContextKey struct{}
+
+// withUserSession looks up the session once, and stores it in the request's context for later calls to
+// getUserSession.
+func (a *Admin) withUserSession(req *http.Request) *http.Request {
+	sess := a.getUserSession(req)
+	return req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, sess))
+}
+
+// getUserSession returns the logged in user's session, or nil if not logged in.
+func (a *Admin) getUserSession(req *http.Request) *Session {
+	if sess, ok := req.Context().Value(sessionContextKey{}).(*Session); ok {
+		return sess
+	}
+
 	cookie, err := req.Cookie("admin")
 	if err != nil {
 		return nil
 	}
 
-	if sess, ok := a.sessions[cookie.Value]; ok {
-		return sess
+	sess, err := a.Sessions.Get(cookie.Value)
+	if err != nil {
+		fmt.Println(err)
+		return nil
 	}
-	return nil
+	return sess
 }
 
-func (a *Admin) logIn(rw http.ResponseWriter, username, password string) bool {
-	if username != a.username || password != a.password {
-		return false
-	}
-	sessKey := randString(32)
-	a.sessions[sessKey] = &session{
-		time:     time.Now(),
-		messages: []*flashMessage{},
+// addMessage adds a flash message to the session, which will be displayed on the next page.
+func (a *Admin) addMessage(sess *Session, class, text string) {
+	if sess == nil {
+		return
 	}
 
-	http.SetCookie(rw, &http.Cookie{
-		Name:  "admin",
-		Value: sessKey,
-		Path:  a.path,
-	})
-	return true
+	sess.addMessage(class, text)
+	err := a.Sessions.Save(sess)
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
-type session struct {
-	time     time.Time
-	messages []*flashMessage
-}
+func (a *Admin) logIn(rw http.ResponseWriter, username, password string) (bool, error) {
+	if a.Auth == nil {
+		return false, nil
+	}
 
-func (s *session) addMessage(class, text string) {
-	s.messages = append(s.messages, &flashMessage{class, text})
-}
+	ok, err := a.Auth.Authenticate(username, password)
+	if !ok || err != nil {
+		return false, err
+	}
 
-func (s *session) getMessages() []*flashMessage {
-	// If empty, there's no need to create a new slice.
-	if len(s.messages) == 0 {
-		return s.messages
+	now := time.Now()
+	sess := &Session{
+		Key:       randString(32),
+		Username:  username,
+		CSRFToken: randString(32),
+		Created:   now,
+		LastSeen:  now,
+		Messages:  []*FlashMessage{},
+	}
+	err = a.Sessions.Save(sess)
+	if err != nil {
+		return false, err
 	}
 
-	messages := s.messages
-	s.messages = []*flashMessage{}
-	return messages
+	http.SetCookie(rw, &http.Cookie{
+		Name:     "admin",
+		Value:    sess.Key,
+		Path:     a.path,
+		HttpOnly: true,
+	})
+	return true, nil
 }
 
-type flashMessage struct {
-	Class string
-	Text  string
+func (a *Admin) logOut(req *http.Request) error {
+	cookie, err := req.Cookie("admin")
+	if err != nil {
+		return nil
+	}
+
+	return a.Sessions.Delete(cookie.Value)
 }
@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	csrfCookie = "admin_csrf"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfToken returns the token that must be sent along with every POST request. Logged in users get a token stored in
+// their session. Anonymous users (on the log in page) get one stored in a separate cookie instead.
+func (a *Admin) csrfToken(rw http.ResponseWriter, req *http.Request) string {
+	if sess := a.getUserSession(req); sess != nil {
+		return sess.CSRFToken
+	}
+
+	if cookie, err := req.Cookie(csrfCookie); err == nil && len(cookie.Value) > 0 {
+		return cookie.Value
+	}
+
+	token := randString(32)
+	http.SetCookie(rw, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     a.path,
+		HttpOnly: true,
+	})
+	return token
+}
+
+// checkCSRF compares the token sent in the form (or the X-CSRF-Token header) with the expected token.
+func (a *Admin) checkCSRF(req *http.Request) bool {
+	var expected string
+	if sess := a.getUserSession(req); sess != nil {
+		expected = sess.CSRFToken
+	} else if cookie, err := req.Cookie(csrfCookie); err == nil {
+		expected = cookie.Value
+	}
+
+	token := req.Header.Get(csrfHeader)
+	if len(token) == 0 {
+		token = req.PostFormValue(csrfField)
+	}
+
+	if len(expected) == 0 || len(token) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
@@ -2,24 +2,183 @@ package db
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 	"strings"
 )
 
+// Queryer is implemented by both *sql.DB and *sql.Tx.
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Dialect hides the differences between the SQL databases the admin supports.
 type Dialect interface {
+	// Queryf formats a query like fmt.Sprintf, and converts ? placeholders to whatever the database expects.
 	Queryf(format string, args ...interface{}) string
+
+	// Quote quotes an identifier, like a table or column name.
+	Quote(name string) string
+
+	// Paginate returns a clause limiting the number of rows returned, starting at offset.
+	Paginate(limit, offset int) string
+
+	// StringAgg returns an aggregate expression that joins the values of expr for all rows with sep.
+	StringAgg(expr, sep string) string
+
+	// Text returns expr as text, so it can be used with LIKE regardless of column type.
+	Text(expr string) string
+
+	// Time returns expr as a time that can be compared with others, however it's stored.
+	Time(expr string) string
+
+	// InsertId runs an INSERT query and returns the value of the new row's pkColumn.
+	InsertId(q Queryer, query, pkColumn string, args ...interface{}) (int64, error)
+
+	// Columns returns the names and types of a table's columns. It's empty if the table doesn't exist.
+	Columns(q Queryer, table string) (map[string]string, error)
+
+	// ColumnType returns the SQL type for a column of the given type. size is the maximum length of Text columns, or 0.
+	ColumnType(t ColumnType, size int) string
 }
 
+// ColumnType is a database independent column type.
+type ColumnType int
+
+const (
+	PrimaryKey ColumnType = iota
+	Integer
+	Float
+	Boolean
+	Time
+	Text
+)
+
+// BaseDialect works with SQLite and other databases that follow the SQL standard closely enough.
 type BaseDialect struct{}
 
 func (BaseDialect) Queryf(format string, args ...interface{}) string {
 	return fmt.Sprintf(format, args...)
 }
 
-type PostgresDialect struct{}
+func (BaseDialect) Quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (BaseDialect) Paginate(limit, offset int) string {
+	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+}
+
+func (BaseDialect) StringAgg(expr, sep string) string {
+	return fmt.Sprintf("GROUP_CONCAT(%v, %v)", expr, quoteString(sep))
+}
+
+func (BaseDialect) Text(expr string) string {
+	return expr
+}
+
+func (BaseDialect) Time(expr string) string {
+	return expr
+}
+
+func (BaseDialect) InsertId(q Queryer, query, pkColumn string, args ...interface{}) (int64, error) {
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Columns looks the table up in information_schema.
+func (d BaseDialect) Columns(q Queryer, table string) (map[string]string, error) {
+	return queryColumns(q, "SELECT column_name, data_type FROM information_schema.columns WHERE table_name = ?", table)
+}
+
+func (BaseDialect) ColumnType(t ColumnType, size int) string {
+	switch t {
+	case PrimaryKey:
+		return "INTEGER PRIMARY KEY AUTOINCREMENT"
+	case Integer:
+		return "INTEGER"
+	case Float:
+		return "REAL"
+	case Boolean:
+		return "BOOLEAN"
+	case Time:
+		return "DATETIME"
+	}
+	if size > 0 {
+		return fmt.Sprintf("VARCHAR(%d)", size)
+	}
+	return "TEXT"
+}
+
+type SQLiteDialect struct {
+	BaseDialect
+}
+
+// Time uses datetime, as times are stored as text, formatted however the driver (or whatever wrote them) chose.
+func (SQLiteDialect) Time(expr string) string {
+	return fmt.Sprintf("datetime(%v)", expr)
+}
+
+func (d SQLiteDialect) Columns(q Queryer, table string) (map[string]string, error) {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%v)", d.Quote(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	// cid, name, type, notnull, dflt_value, pk
+	columns := map[string]string{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return nil, err
+		}
+		columns[name] = colType
+	}
+	return columns, rows.Err()
+}
+
+type MySQLDialect struct {
+	BaseDialect
+}
+
+func (MySQLDialect) Quote(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (MySQLDialect) Columns(q Queryer, table string) (map[string]string, error) {
+	return queryColumns(q, "SELECT column_name, column_type FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?", table)
+}
+
+func (d MySQLDialect) ColumnType(t ColumnType, size int) string {
+	switch t {
+	case PrimaryKey:
+		return "INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY"
+	case Float:
+		return "DOUBLE"
+	}
+	return d.BaseDialect.ColumnType(t, size)
+}
+
+func (MySQLDialect) StringAgg(expr, sep string) string {
+	return fmt.Sprintf("GROUP_CONCAT(%v SEPARATOR %v)", expr, quoteString(sep))
+}
+
+type PostgresDialect struct {
+	BaseDialect
+}
+
+// Queryf formats the query first, so placeholders in clauses passed as arguments are numbered too.
 func (PostgresDialect) Queryf(format string, args ...interface{}) string {
-	parts := strings.Split(format, "?")
+	parts := strings.Split(fmt.Sprintf(format, args...), "?")
 	var buf bytes.Buffer
 
 	for i, part := range parts {
@@ -28,5 +187,61 @@ func (PostgresDialect) Queryf(format string, args ...interface{}) string {
 			buf.WriteString(fmt.Sprintf("$%d", i+1))
 		}
 	}
-	return fmt.Sprintf(buf.String(), args...)
+	return buf.String()
+}
+
+func (PostgresDialect) StringAgg(expr, sep string) string {
+	return fmt.Sprintf("STRING_AGG(CAST(%v AS TEXT), %v)", expr, quoteString(sep))
+}
+
+func (PostgresDialect) Text(expr string) string {
+	return fmt.Sprintf("CAST(%v AS TEXT)", expr)
+}
+
+func (PostgresDialect) Columns(q Queryer, table string) (map[string]string, error) {
+	return queryColumns(q, "SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", table)
+}
+
+func (d PostgresDialect) ColumnType(t ColumnType, size int) string {
+	switch t {
+	case PrimaryKey:
+		return "SERIAL PRIMARY KEY"
+	case Float:
+		return "DOUBLE PRECISION"
+	case Time:
+		return "TIMESTAMP"
+	}
+	return d.BaseDialect.ColumnType(t, size)
+}
+
+// InsertId uses RETURNING, as Postgres drivers don't support LastInsertId.
+func (d PostgresDialect) InsertId(q Queryer, query, pkColumn string, args ...interface{}) (int64, error) {
+	var id int64
+	err := q.QueryRow(fmt.Sprintf("%v RETURNING %v", query, d.Quote(pkColumn)), args...).Scan(&id)
+	return id, err
+}
+
+// queryColumns runs a query returning column names and types.
+func queryColumns(q Queryer, query, table string) (map[string]string, error) {
+	rows, err := q.Query(query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]string{}
+	for rows.Next() {
+		var name, colType string
+		err = rows.Scan(&name, &colType)
+		if err != nil {
+			return nil, err
+		}
+		columns[name] = colType
+	}
+	return columns, rows.Err()
+}
+
+// quoteString returns s as an SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
 }
@@ -23,7 +23,7 @@ type BlogPost struct {
 	Id        int       `orm:"auto"`
 	Category  *Category `admin:"list='Title' label='Category' width=2" orm:"rel(fk)"` // list='Title' is used to show Category.Title instead of Category.Id in list view.
 	Title     string    `admin:"list search width=7"`
-	Photo     string    `admin:"width=3 field='file' upload_to='static/posts'"` // File field
+	Photo     string    `admin:"width=3 field='image' upload_to='static/posts' min_width=640"` // Image field
 	Body      string    `admin:"textarea" orm:"type(text)"`
 	Published time.Time `admin:"list width=11"`
 	Draft     bool      `admin:"list width=1"`
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/oal/admin/fields"
+)
+
+// Spreadsheets treat cells starting with these characters as formulas
+const formulaChars = "=+-@\t\r"
+
+// csvEscape adds a ' to values that would be run as formulas when the file is opened in a spreadsheet. Numbers are left
+// as they are.
+func csvEscape(val string) string {
+	if len(val) > 0 && strings.IndexByte(formulaChars, val[0]) >= 0 {
+		if _, err := strconv.ParseFloat(val, 64); err != nil {
+			return "'" + val
+		}
+	}
+	return val
+}
+
+// csvUnescape removes the ' added by csvEscape, so exported files can be imported again.
+func csvUnescape(val string) string {
+	if len(val) > 1 && val[0] == '\'' && strings.IndexByte(formulaChars, val[1]) >= 0 {
+		return val[1:]
+	}
+	return val
+}
+
+// export calls fn with every row matching search and filters, in the list view's order.
+func (m *model) export(search string, filters url.Values, sortBy string, sortDesc bool, fn func([]interface{}) error) error {
+	rowQuery, _, args := m.listQuery(search, filters, sortBy, sortDesc)
+	return m.eachRow(m.admin.dialect.Queryf("%v", rowQuery), args, fn)
+}
+
+// handleExport streams the list view as CSV, with all pages and the same search, filters and sorting.
+func (a *Admin) handleExport(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	slug := ps.ByName("slug")
+	model, ok := a.models[slug]
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	req.ParseForm()
+	q, sortBy, sortDesc, _ := listParams(model, req)
+
+	rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v.csv"`, slug))
+
+	w := csv.NewWriter(rw)
+	header := []string{}
+	for _, field := range model.listFields {
+		header = append(header, field.Attrs().Label)
+	}
+	w.Write(header)
+
+	err := model.export(q, req.Form, sortBy, sortDesc, func(row []interface{}) error {
+		record := make([]string, len(row))
+		for i, val := range row {
+			record[i] = csvEscape(fields.RenderText(model.listFields[i], val))
+		}
+		return w.Write(record)
+	})
+	if err != nil {
+		// Headers are already sent, so the file will just be cut short
+		fmt.Println(err)
+	}
+	w.Flush()
+}
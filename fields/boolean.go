@@ -30,7 +30,7 @@ func (b *BooleanField) Validate(val string) (interface{}, error) {
 
 func (b *BooleanField) RenderString(val interface{}) template.HTML {
 	s := `<span class="glyphicon %v"></span>`
-	if i, ok := val.(int64); ok && i == 1 {
+	if b.RenderText(val) == "true" {
 		s = fmt.Sprintf(s, "text-success glyphicon-ok")
 	} else {
 		s = fmt.Sprintf(s, "text-danger glyphicon-remove")
@@ -38,6 +38,16 @@ func (b *BooleanField) RenderString(val interface{}) template.HTML {
 	return template.HTML(template.HTML(s))
 }
 
+func (b *BooleanField) RenderText(val interface{}) string {
+	if i, ok := val.(int64); ok {
+		return strconv.FormatBool(i == 1)
+	}
+	if bl, ok := val.(bool); ok {
+		return strconv.FormatBool(bl)
+	}
+	return "false"
+}
+
 func (b *BooleanField) BaseRender(w io.Writer, value interface{}, errStr string, startRow bool, ctx map[string]interface{}) {
 	if ctx == nil {
 		ctx = map[string]interface{}{}
@@ -1,16 +1,26 @@
 package fields
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"mime"
 	"mime/multipart"
-	"os"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
 )
 
 var fileTemplate = template.Must(template.New("template").Parse(`
-	<input id="{{.name}}" name="{{.name}}" type="file">
-	<p>{{if .value}}Existing: {{.value}}{{end}}</p>
+	<input id="{{.name}}" name="{{.name}}" type="file"{{if .accept}} accept="{{.accept}}"{{end}}>
+	<p>{{if .value}}Existing: {{if .url}}<a href="{{.url}}" target="_blank">{{.value}}</a>{{else}}{{.value}}{{end}}{{end}}</p>
+	{{if and .value .blank}}
+		<div class="checkbox">
+			<label><input type="checkbox" name="{{.name}}-clear" value="true"> Clear</label>
+		</div>
+	{{end}}
 	{{if .help}}
 		<div class="help text">
 			<pre>{{.help}}</pre>
@@ -18,48 +28,164 @@ var fileTemplate = template.Must(template.New("template").Parse(`
 	{{end}}
 `))
 
+// FileField stores uploaded files in a Storage. Uploads can be limited to max_size (in bytes, or with a KB / MB / GB
+// suffix) and to the types listed in accept (content types like image/png or image/*, or extensions like .pdf). With
+// the cleanup tag, files that are replaced, cleared or belong to deleted rows are deleted.
 type FileField struct {
 	*BaseField
 	UploadTo string
+	Storage  Storage
+	MaxSize  int64
+	Accept   []string
+	Cleanup  bool
 }
 
 func (f *FileField) Configure(tagMap map[string]string) error {
 	if dir, ok := tagMap["upload_to"]; ok {
 		f.UploadTo = dir
 	}
+	if size, ok := tagMap["max_size"]; ok {
+		var err error
+		f.MaxSize, err = parseSize(size)
+		if err != nil {
+			return err
+		}
+	}
+	if accept, ok := tagMap["accept"]; ok {
+		for _, typ := range strings.Split(accept, ",") {
+			if typ = strings.ToLower(strings.TrimSpace(typ)); len(typ) > 0 {
+				f.Accept = append(f.Accept, typ)
+			}
+		}
+	}
+	if _, ok := tagMap["cleanup"]; ok {
+		f.Cleanup = true
+	}
+	if name, ok := tagMap["storage"]; ok {
+		f.Storage = GetStorage(name)
+		if f.Storage == nil {
+			return errors.New(fmt.Sprintf("Storage %v is not registered.", name))
+		}
+	}
 	return nil
 }
 
+// storage returns the field's storage, or DefaultStorage.
+func (f *FileField) storage() Storage {
+	if f.Storage != nil {
+		return f.Storage
+	}
+	return DefaultStorage
+}
+
 func (f *FileField) Render(w io.Writer, val interface{}, err string, startRow bool) {
-	f.BaseRender(w, fileTemplate, val, err, startRow, nil)
+	ctx := map[string]interface{}{"accept": strings.Join(f.Accept, ",")}
+	if name, ok := val.(string); ok && len(name) > 0 {
+		ctx["url"] = f.storage().URL(name)
+	}
+	f.BaseRender(w, fileTemplate, val, err, startRow, ctx)
 }
 
 func (f *FileField) Validate(val string) (interface{}, error) {
 	return val, nil
 }
 
+// HandleFile stores an uploaded file in upload_to, under a cleaned up version of its name. If the name is taken, a
+// number is added to it.
 func (f *FileField) HandleFile(file *multipart.FileHeader) (string, error) {
 	reader, err := file.Open()
 	if err != nil {
 		return "", err
 	}
+	defer reader.Close()
 
-	filename := file.Filename
-	if len(f.UploadTo) > 0 {
-		_, err := os.Stat(f.UploadTo)
-		if err != nil {
-			os.MkdirAll(f.UploadTo, 0777)
+	// The start of the file is enough to tell its type
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	err = f.checkFile(file, head[:n])
+	if err != nil {
+		return "", err
+	}
+	_, err = reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := f.storage().Save(path.Join(f.UploadTo, cleanFilename(file.Filename)), reader)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("The file could not be saved: %v", err))
+	}
+	return name, nil
+}
+
+// checkFile makes sure an upload is within max_size, and is of a type in accept. The type is detected from the start of
+// the file, as the type sent by the browser can't be trusted.
+func (f *FileField) checkFile(file *multipart.FileHeader, head []byte) error {
+	if f.MaxSize > 0 && file.Size > f.MaxSize {
+		return errors.New(fmt.Sprintf("The file can't be larger than %v.", FormatSize(f.MaxSize)))
+	}
+	if len(f.Accept) == 0 {
+		return nil
+	}
+
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	ext := strings.ToLower(path.Ext(file.Filename))
+	for _, typ := range f.Accept {
+		switch {
+		case strings.HasPrefix(typ, "."):
+			if ext == typ {
+				return nil
+			}
+		case strings.HasSuffix(typ, "/*"):
+			if strings.HasPrefix(contentType, strings.TrimSuffix(typ, "*")) {
+				return nil
+			}
+		case contentType == typ:
+			return nil
 		}
-		filename = fmt.Sprintf("%v/%v", f.UploadTo, file.Filename)
 	}
+	return errors.New(fmt.Sprintf("Files of this type (%v) are not allowed. Allowed types: %v", contentType, strings.Join(f.Accept, ", ")))
+}
 
-	dst, err := os.Create(filename)
+func (f *FileField) CleanupFiles() bool {
+	return f.Cleanup
+}
+
+// DeleteFile removes a file stored by HandleFile.
+func (f *FileField) DeleteFile(name string) error {
+	return f.storage().Delete(name)
+}
+
+// parseSize parses a number of bytes, optionally followed by KB, MB or GB.
+func parseSize(val string) (int64, error) {
+	val = strings.ToUpper(strings.TrimSpace(val))
+	multiplier := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB"} {
+		if strings.HasSuffix(val, suffix) {
+			multiplier = 1 << (10 * uint(i+1))
+			val = strings.TrimSpace(strings.TrimSuffix(val, suffix))
+			break
+		}
+	}
+	size, err := strconv.ParseInt(val, 10, 64)
 	if err != nil {
-		return "", err
+		return 0, errors.New(fmt.Sprintf("Invalid size: %v", val))
 	}
+	return size * multiplier, nil
+}
 
-	io.Copy(dst, reader)
-	dst.Close()
-	reader.Close()
-	return filename, nil
+// FormatSize formats a number of bytes for messages, like "2 MB".
+func FormatSize(size int64) string {
+	for i, suffix := range []string{"GB", "MB", "KB"} {
+		unit := int64(1) << (10 * uint(3-i))
+		if size >= unit && size%unit == 0 {
+			return fmt.Sprintf("%v %v", size/unit, suffix)
+		} else if size >= unit {
+			return fmt.Sprintf("%.1f %v", float64(size)/float64(unit), suffix)
+		}
+	}
+	return fmt.Sprintf("%v bytes", size)
 }
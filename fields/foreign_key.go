@@ -5,12 +5,17 @@ import (
 	"io"
 )
 
+// The id is kept in a hidden input, while the related row's display value is shown and searched for in the text input
 var foreignKeyTemplate = template.Must(template.New("template").Parse(`
-	<div class="input-group">
-		<input id="{{.name}}" name="{{.name}}" type="text" value="{{.value}}" class="form-control">
-		<span class="input-group-btn">
-			<button class="btn btn-default btn-fk-search" type="button" data-name="{{.name}}" data-slug="{{.modelSlug}}">Search...</button>
-		</span>
+	<div class="autocomplete" data-slug="{{.modelSlug}}" data-display="{{.listColumn}}">
+		<input id="{{.name}}" name="{{.name}}" type="hidden" value="{{.value}}" class="autocomplete-value">
+		<div class="input-group">
+			<input type="text" class="form-control autocomplete-input" placeholder="Type to search..." autocomplete="off">
+			<span class="input-group-btn">
+				<button class="btn btn-default btn-fk-search" type="button" data-name="{{.name}}" data-slug="{{.modelSlug}}">Search...</button>
+			</span>
+		</div>
+		<ul class="dropdown-menu autocomplete-results"></ul>
 	</div>
 `))
 
@@ -24,7 +29,8 @@ type ForeignKeyField struct {
 
 func (f *ForeignKeyField) Render(w io.Writer, val interface{}, err string, startRow bool) {
 	f.BaseRender(w, foreignKeyTemplate, val, err, startRow, map[string]interface{}{
-		"modelSlug": f.model,
+		"modelSlug":  f.model,
+		"listColumn": f.column,
 	})
 }
 func (f *ForeignKeyField) Validate(val string) (interface{}, error) {
@@ -0,0 +1,235 @@
+package fields
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var imageTemplate = template.Must(template.New("template").Parse(`
+	<input id="{{.name}}" name="{{.name}}" type="file" accept="{{if .accept}}{{.accept}}{{else}}image/jpeg,image/png,image/gif{{end}}">
+	{{if .value}}
+		<p>
+			{{if .thumbURL}}<a href="{{.url}}" target="_blank"><img src="{{.thumbURL}}" alt="" class="img-thumbnail"></a><br>{{end}}
+			Existing: {{.value}}
+		</p>
+		{{if .blank}}
+			<div class="checkbox">
+				<label><input type="checkbox" name="{{.name}}-clear" value="true"> Clear</label>
+			</div>
+		{{end}}
+	{{end}}
+	{{if .help}}
+		<div class="help text">
+			<pre>{{.help}}</pre>
+		</div>
+	{{end}}
+`))
+
+// Content types of the image formats that can be decoded
+var imageTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// MaxImagePixels limits the width * height of uploaded images, whatever their max_width and max_height, as a small file
+// can claim to be a huge image that takes gigabytes of memory to decode.
+var MaxImagePixels = 50 * 1000 * 1000
+
+// ImageField is a FileField for JPEG, PNG and GIF images. Uploads are checked against the min_width, min_height,
+// max_width and max_height tags (in pixels), and a thumbnail thumb_width pixels wide is stored next to each image.
+type ImageField struct {
+	*FileField
+	MinWidth   int
+	MinHeight  int
+	MaxWidth   int
+	MaxHeight  int
+	ThumbWidth int
+}
+
+func (f *ImageField) Configure(tagMap map[string]string) error {
+	err := f.FileField.Configure(tagMap)
+	if err != nil {
+		return err
+	}
+
+	f.ThumbWidth = 150
+	for tag, dst := range map[string]*int{"min_width": &f.MinWidth, "min_height": &f.MinHeight, "max_width": &f.MaxWidth,
+		"max_height": &f.MaxHeight, "thumb_width": &f.ThumbWidth} {
+		if val, ok := tagMap[tag]; ok {
+			*dst, err = strconv.Atoi(val)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *ImageField) Render(w io.Writer, val interface{}, err string, startRow bool) {
+	ctx := map[string]interface{}{"accept": strings.Join(f.Accept, ",")}
+	if name, ok := val.(string); ok && len(name) > 0 {
+		ctx["url"] = f.storage().URL(name)
+		ctx["thumbURL"] = f.storage().URL(thumbnailName(name))
+	}
+	f.BaseRender(w, imageTemplate, val, err, startRow, ctx)
+}
+
+// RenderString shows the thumbnail in the list view, if the storage can serve it.
+func (f *ImageField) RenderString(val interface{}) template.HTML {
+	name, ok := val.(string)
+	if !ok {
+		if b, isBytes := val.([]byte); isBytes {
+			name, ok = string(b), true
+		}
+	}
+	if ok && len(name) > 0 {
+		if url := f.storage().URL(thumbnailName(name)); len(url) > 0 {
+			return template.HTML(fmt.Sprintf(`<img src="%v" alt="" class="img-thumbnail" style="max-height: 50px">`, template.HTMLEscapeString(url)))
+		}
+	}
+	return f.BaseField.RenderString(val)
+}
+
+// HandleFile checks an uploaded image, and stores it along with its thumbnail.
+func (f *ImageField) HandleFile(file *multipart.FileHeader) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = f.checkFile(file, data)
+	if err != nil {
+		return "", err
+	}
+	if !imageTypes[http.DetectContentType(data)] {
+		return "", errors.New("The file must be a JPEG, PNG or GIF image.")
+	}
+
+	// The size is checked before decoding, so only images of an acceptable size are loaded into memory
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", errors.New("The image could not be read.")
+	}
+	err = f.checkSize(config.Width, config.Height)
+	if err != nil {
+		return "", err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", errors.New("The image could not be read.")
+	}
+
+	var thumb bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&thumb, thumbnail(img, f.ThumbWidth), &jpeg.Options{Quality: 85})
+	default:
+		err = png.Encode(&thumb, thumbnail(img, f.ThumbWidth))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The thumbnail's name is derived from the image's, so if it's taken (by a file left over in the storage) the image is
+	// stored under another name, and the taken names are freed again once a pair of free names is found.
+	taken := []string{}
+	defer func() {
+		for _, name := range taken {
+			f.storage().Delete(name)
+		}
+	}()
+	for i := 0; i < maxNameAttempts; i++ {
+		name, err := f.storage().Save(path.Join(f.UploadTo, cleanFilename(file.Filename)), bytes.NewReader(data))
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("The file could not be saved: %v", err))
+		}
+		thumbName, err := f.storage().Save(thumbnailName(name), bytes.NewReader(thumb.Bytes()))
+		if err != nil {
+			f.storage().Delete(name)
+			return "", errors.New(fmt.Sprintf("The thumbnail could not be saved: %v", err))
+		}
+		if thumbName == thumbnailName(name) {
+			return name, nil
+		}
+		taken = append(taken, name, thumbName)
+	}
+	return "", errors.New("The thumbnail could not be saved: no free name was found.")
+}
+
+// DeleteFile removes an image and its thumbnail.
+func (f *ImageField) DeleteFile(name string) error {
+	f.storage().Delete(thumbnailName(name))
+	return f.storage().Delete(name)
+}
+
+func (f *ImageField) checkSize(width, height int) error {
+	switch {
+	case width <= 0 || height <= 0 || width > MaxImagePixels/height:
+		return errors.New("The image is too large to be read.")
+	case f.MinWidth > 0 && width < f.MinWidth:
+		return errors.New(fmt.Sprintf("The image must be at least %v pixels wide.", f.MinWidth))
+	case f.MinHeight > 0 && height < f.MinHeight:
+		return errors.New(fmt.Sprintf("The image must be at least %v pixels high.", f.MinHeight))
+	case f.MaxWidth > 0 && width > f.MaxWidth:
+		return errors.New(fmt.Sprintf("The image can't be more than %v pixels wide.", f.MaxWidth))
+	case f.MaxHeight > 0 && height > f.MaxHeight:
+		return errors.New(fmt.Sprintf("The image can't be more than %v pixels high.", f.MaxHeight))
+	}
+	return nil
+}
+
+// thumbnailName returns the name the thumbnail of an image is stored under, next to it. It starts with an underscore,
+// which cleanFilename removes from uploaded names, so uploads never take it.
+func thumbnailName(name string) string {
+	ext := path.Ext(name)
+	if ext != ".jpg" && ext != ".jpeg" {
+		ext = ".png"
+	}
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	return path.Join(path.Dir(name), fmt.Sprintf("_%v.thumb%v", base, ext))
+}
+
+// thumbnail scales an image down to the given width, averaging the pixels each thumbnail pixel covers.
+func thumbnail(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if width <= 0 || width > bounds.Dx() {
+		width = bounds.Dx()
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	thumb := image.NewRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := bounds.Min.Y+y*bounds.Dy()/height, bounds.Min.Y+(y+1)*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := bounds.Min.X+x*bounds.Dx()/width, bounds.Min.X+(x+1)*bounds.Dx()/width
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			thumb.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return thumb
+}
@@ -18,8 +18,20 @@ type Field interface {
 	Attrs() *BaseField
 }
 
+// TextRenderer is implemented by fields that render values as plain text for exports. See RenderText.
+type TextRenderer interface {
+	RenderText(val interface{}) string
+}
+
 type FileHandlerField interface {
 	HandleFile(*multipart.FileHeader) (string, error)
+	DeleteFile(string) error
+}
+
+// CleanupField is a FileHandlerField that may want files it no longer uses to be deleted.
+type CleanupField interface {
+	FileHandlerField
+	CleanupFiles() bool
 }
 
 type RelationalField interface {
@@ -41,10 +53,14 @@ type BaseField struct {
 	ColumnName    string
 	List          bool
 	Searchable    bool
+	Filter        bool
 	Width         int
 	Right         bool
 	Help          string
 	RelationTable string
+	RelationFrom  string
+	RelationTo    string
+	RelationOrder string
 }
 
 func (b *BaseField) Configure(tagMap map[string]string) error {
@@ -59,10 +75,26 @@ func (b *BaseField) RenderString(val interface{}) template.HTML {
 	return template.HTML(template.HTMLEscapeString(fmt.Sprintf("%v", val)))
 }
 
+// RenderText is like RenderString, but returns plain text for exports.
+func (b *BaseField) RenderText(val interface{}) string {
+	if val == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", val)
+}
+
 func (b *BaseField) Attrs() *BaseField {
 	return b
 }
 
+// RenderText renders a value as plain text, with the field's RenderText if it has one, or like BaseField does.
+func RenderText(field Field, val interface{}) string {
+	if t, ok := field.(TextRenderer); ok {
+		return t.RenderText(val)
+	}
+	return field.Attrs().RenderText(val)
+}
+
 func (b *BaseField) BaseRender(w io.Writer, tmpl *template.Template, value interface{}, errStr string, startRow bool, ctx map[string]interface{}) {
 	if ctx == nil {
 		ctx = map[string]interface{}{}
@@ -90,8 +122,9 @@ func (b *BaseField) BaseRender(w io.Writer, tmpl *template.Template, value inter
 }
 
 var customFields = map[string]Field{
-	"url":  &URLField{&BaseField{}},
-	"file": &FileField{&BaseField{}, ""},
+	"url":   &URLField{&BaseField{}},
+	"file":  &FileField{BaseField: &BaseField{}},
+	"image": &ImageField{FileField: &FileField{BaseField: &BaseField{}}},
 }
 
 func RegisterCustom(name string, field Field) error {
@@ -119,15 +152,23 @@ func Validate(field Field, req *http.Request, existing interface{}) (interface{}
 	fieldName := field.Attrs().Name
 	rawValue := req.Form.Get(fieldName)
 
-	// If file field (and no rawValue), handle file
+	// File fields only get their value from an upload or the existing value, never from posted text, as it names a
+	// stored file that may be deleted later
 	if fileField, ok := field.(FileHandlerField); ok {
-		files, ok := req.MultipartForm.File[fieldName]
-		if ok {
+		rawValue = ""
+		var files []*multipart.FileHeader
+		if req.MultipartForm != nil {
+			files = req.MultipartForm.File[fieldName]
+		}
+		if len(files) > 0 {
 			filename, err := fileField.HandleFile(files[0])
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 			rawValue = filename
+		} else if req.Form.Get(fieldName+"-clear") == "true" && field.Attrs().Blank {
+			// Blank file fields have a checkbox to remove the file
+			rawValue = ""
 		} else if oldValue, ok := existing.(string); ok {
 			rawValue = oldValue
 		}
@@ -136,8 +177,13 @@ func Validate(field Field, req *http.Request, existing interface{}) (interface{}
 	// In POSTed data, a bool / checkbox always has 0 length, so don't treat it as an empty field
 	val, err := field.Validate(rawValue)
 	_, isBool := val.(bool)
+	_, isM2M := val.([]string)
 	if len(rawValue) == 0 && !isBool {
 		if field.Attrs().Blank {
+			// No ids means no relations, not an empty column
+			if isM2M {
+				return val, nil
+			}
 			if field.Attrs().Null {
 				return nil, nil
 			}
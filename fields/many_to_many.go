@@ -3,16 +3,21 @@ package fields
 import (
 	"html/template"
 	"io"
-	"strconv"
 	"strings"
 )
 
+// Like foreignKeyTemplate, with the selected rows listed below the input
 var m2mTemplate = template.Must(template.New("template").Parse(`
-	<div class="input-group">
-		<input id="{{.name}}" name="{{.name}}" type="text" value="{{.value}}" data-multiple="true" class="form-control">
-		<span class="input-group-btn">
-			<button class="btn btn-default btn-m2m-search" type="button" data-name="{{.name}}" data-slug="{{.modelSlug}}">Search...</button>
-		</span>
+	<div class="autocomplete" data-slug="{{.modelSlug}}" data-display="{{.listColumn}}" data-multiple="true">
+		<input id="{{.name}}" name="{{.name}}" type="hidden" value="{{.value}}" data-multiple="true"{{if .ordered}} data-ordered="true"{{end}} class="autocomplete-value">
+		<div class="input-group">
+			<input type="text" class="form-control autocomplete-input" placeholder="Type to search..." autocomplete="off">
+			<span class="input-group-btn">
+				<button class="btn btn-default btn-m2m-search" type="button" data-name="{{.name}}" data-slug="{{.modelSlug}}">Search...</button>
+			</span>
+		</div>
+		<ul class="dropdown-menu autocomplete-results"></ul>
+		<ul class="list-inline autocomplete-selected"></ul>
 	</div>
 `))
 
@@ -26,31 +31,26 @@ type ManyToManyField struct {
 
 func (m *ManyToManyField) Render(w io.Writer, val interface{}, err string, startRow bool) {
 	// Get the formatting right
-	ids, ok := val.([]int)
-	if ok {
-		strIds := make([]string, len(ids))
-		for i, id := range ids {
-			strIds[i] = strconv.FormatInt(int64(id), 10)
-		}
-		val = strings.Join(strIds, ", ")
+	if ids, ok := val.([]string); ok {
+		val = strings.Join(ids, ", ")
 	}
 	m.BaseRender(w, m2mTemplate, val, err, startRow, map[string]interface{}{
-		"modelSlug": m.model,
+		"modelSlug":  m.model,
+		"listColumn": m.column,
+		"ordered":    m.RelationOrder != "",
 	})
 }
 
 func (m *ManyToManyField) Validate(val string) (interface{}, error) {
 	idStr := strings.Split(val, ",")
-	ids := []int{}
+	ids := []string{}
 
 	for _, s := range idStr {
 		s = strings.TrimSpace(s)
-		id, err := strconv.ParseUint(s, 10, 64)
-		if err != nil {
-			return nil, err
+		if len(s) == 0 {
+			continue
 		}
-
-		ids = append(ids, int(id))
+		ids = append(ids, s)
 	}
 
 	return ids, nil
@@ -0,0 +1,331 @@
+package fields
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage saves uploaded files for FileFields. Save stores a file under name, or a similar name if it's taken, and
+// returns the name it was stored under. URL returns where a stored file can be downloaded, or "" if it can't.
+type Storage interface {
+	Save(name string, r io.Reader) (string, error)
+	Delete(name string) error
+	URL(name string) string
+}
+
+// DefaultStorage is used by file fields without a storage tag. It stores files relative to the working directory, and
+// has no BaseURL, so its files aren't linked to or shown as thumbnails.
+var DefaultStorage Storage = &LocalStorage{Root: "."}
+
+var storages = map[string]Storage{}
+
+// RegisterStorage makes a storage available to file fields as storage='name'. Storages must be registered before the
+// models using them.
+func RegisterStorage(name string, storage Storage) error {
+	if _, ok := storages[name]; ok {
+		return errors.New(fmt.Sprintf("A storage with the name %v already exists.", name))
+	}
+	storages[name] = storage
+	return nil
+}
+
+func GetStorage(name string) Storage {
+	if storage, ok := storages[name]; ok {
+		return storage
+	}
+	return nil
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// cleanFilename strips directories and unusual characters from an uploaded file's name.
+func cleanFilename(filename string) string {
+	filename = path.Base(strings.Replace(filename, "\\", "/", -1))
+	filename = strings.TrimLeft(unsafeFilenameChars.ReplaceAllString(filename, "_"), "._")
+	if len(filename) == 0 {
+		return "file"
+	}
+	return filename
+}
+
+// numberedName returns name with -i added before its extension, or name itself for i = 0.
+func numberedName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	ext := path.Ext(name)
+	return fmt.Sprintf("%v-%v%v", strings.TrimSuffix(name, ext), i, ext)
+}
+
+// How many numbered names to try before giving up
+const maxNameAttempts = 1000
+
+// LocalStorage stores files in a directory. Names can't point outside of Root. Files are served from BaseURL if set.
+type LocalStorage struct {
+	Root    string
+	BaseURL string
+}
+
+func (s *LocalStorage) Save(name string, r io.Reader) (string, error) {
+	for i := 0; i < maxNameAttempts; i++ {
+		candidate := numberedName(name, i)
+		filename, err := s.path(candidate)
+		if err != nil {
+			return "", err
+		}
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			return "", err
+		}
+
+		// Never overwrite existing files
+		dst, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		_, err = io.Copy(dst, r)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(filename)
+			return "", err
+		}
+		return candidate, nil
+	}
+	return "", errors.New(fmt.Sprintf("Could not find a free name for %v.", name))
+}
+
+func (s *LocalStorage) Delete(name string) error {
+	filename, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+func (s *LocalStorage) URL(name string) string {
+	if len(s.BaseURL) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + name
+}
+
+// path returns the file name of a stored file, making sure it's inside Root.
+func (s *LocalStorage) path(name string) (string, error) {
+	root, err := filepath.Abs(s.Root)
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(root, filepath.FromSlash(name))
+	if !strings.HasPrefix(filename, root+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("%v is outside of the upload directory.", name))
+	}
+	return filename, nil
+}
+
+// MemoryStorage keeps files in memory, for tests.
+type MemoryStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *MemoryStorage) Save(name string, r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = map[string][]byte{}
+	}
+	for i := 0; i < maxNameAttempts; i++ {
+		candidate := numberedName(name, i)
+		if _, ok := s.files[candidate]; !ok {
+			s.files[candidate] = data
+			return candidate, nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("Could not find a free name for %v.", name))
+}
+
+func (s *MemoryStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return errors.New(fmt.Sprintf("%v doesn't exist.", name))
+	}
+	delete(s.files, name)
+	return nil
+}
+
+func (s *MemoryStorage) URL(name string) string {
+	return ""
+}
+
+// Get returns the contents of a stored file.
+func (s *MemoryStorage) Get(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	return data, ok
+}
+
+// S3Storage stores files in a bucket on Amazon S3 or a compatible service, using path-style URLs
+// (<Endpoint>/<Bucket>/<name>). Files are served from BaseURL if set.
+type S3Storage struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	BaseURL   string
+	Client    *http.Client
+}
+
+func (s *S3Storage) Save(name string, r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	for i := 0; i < maxNameAttempts; i++ {
+		candidate := numberedName(name, i)
+		resp, err := s.do("HEAD", candidate, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode == 200 {
+			continue
+		} else if resp.StatusCode != 404 {
+			return "", errors.New(fmt.Sprintf("Could not check for %v: %v", candidate, resp.Status))
+		}
+
+		// Services that support it refuse to overwrite objects added since
+		resp, err = s.do("PUT", candidate, data, map[string]string{"If-None-Match": "*"})
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode == 412 {
+			continue
+		} else if resp.StatusCode != 200 {
+			return "", errors.New(fmt.Sprintf("Could not upload %v: %v", candidate, resp.Status))
+		}
+		return candidate, nil
+	}
+	return "", errors.New(fmt.Sprintf("Could not find a free name for %v.", name))
+}
+
+func (s *S3Storage) Delete(name string) error {
+	resp, err := s.do("DELETE", name, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return errors.New(fmt.Sprintf("Could not delete %v: %v", name, resp.Status))
+	}
+	return nil
+}
+
+func (s *S3Storage) URL(name string) string {
+	if len(s.BaseURL) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + s3Escape(name)
+}
+
+// do sends a request signed with AWS Signature Version 4, and closes the response body.
+func (s *S3Storage) do(method, name string, body []byte, headers map[string]string) (*http.Response, error) {
+	uri := "/" + s3Escape(s.Bucket) + "/" + s3Escape(name)
+	req, err := http.NewRequest(method, strings.TrimSuffix(s.Endpoint, "/")+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		method,
+		uri,
+		"",
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%v/%v/s3/aws4_request", date, s.Region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
+
+// s3Escape escapes each segment of an object name the way S3 expects in signed requests.
+func s3Escape(name string) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(name) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-._~/", b) >= 0 {
+			buf.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
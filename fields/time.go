@@ -32,6 +32,9 @@ func (t *TimeField) Render(w io.Writer, val interface{}, err string, startRow bo
 	formatted := ""
 	if tm, ok := val.(time.Time); ok {
 		formatted = tm.Format(t.Format)
+	} else if str, ok := val.(string); ok {
+		// Submitted, but invalid
+		formatted = str
 	}
 	t.BaseRender(w, timeTemplate, formatted, err, startRow, map[string]interface{}{
 		"format": t.Format,
@@ -45,9 +48,20 @@ func (t *TimeField) RenderString(val interface{}) template.HTML {
 	return template.HTML("")
 }
 
+func (t *TimeField) RenderText(val interface{}) string {
+	if maybeTime, ok := val.(time.Time); ok {
+		return maybeTime.Format(t.Format)
+	}
+	return ""
+}
+
 func (t *TimeField) Validate(val string) (interface{}, error) {
 	tm, err := time.Parse(t.Format, val)
 	if err != nil {
+		// Structs saved with Model.Save keep the full time
+		if full, rfcErr := time.Parse(time.RFC3339Nano, val); rfcErr == nil {
+			return full, nil
+		}
 		return nil, err
 	}
 	return tm, nil
@@ -0,0 +1,221 @@
+package admin
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/oal/admin/fields"
+)
+
+// Filters are set with the "filter" tag, and shown in a sidebar in the list view. The kind of filter depends on the
+// field type. Values are carried in the URL as filter.<FieldName>, or filter.<FieldName>.min / .max and .from / .to
+// for ranges.
+const filterPrefix = "filter."
+
+// filterTimeLayout is how date filters' bounds are passed to the database.
+const filterTimeLayout = "2006-01-02 15:04:05"
+
+// filterView is what the list template needs to render one filter in the sidebar.
+type filterView struct {
+	Label   string
+	Name    string
+	Options []*filterOption
+
+	// Range filters (numbers and custom dates) are rendered as a small form
+	Range    bool
+	Type     string
+	MinName  string
+	MinValue string
+	MaxName  string
+	MaxValue string
+	Hidden   url.Values
+}
+
+type filterOption struct {
+	Label  string
+	URL    template.URL
+	Active bool
+}
+
+// dateRange returns the start and end of one of the date filter presets. Times entered in forms are stored as UTC, so
+// the bounds are the local dates in UTC too. Otherwise they'd be written differently from the rows by drivers that store
+// times as text, like SQLite's, and couldn't be compared with them.
+func dateRange(preset string, now time.Time) (time.Time, time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch preset {
+	case "today":
+		return today, today.AddDate(0, 0, 1), true
+	case "week":
+		return today.AddDate(0, 0, -6), today.AddDate(0, 0, 1), true
+	case "month":
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return month, month.AddDate(0, 1, 0), true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// filterConds returns SQL conditions and arguments for the filters set in values. Invalid values are ignored.
+func (m *model) filterConds(values url.Values) ([]string, []interface{}) {
+	conds := []string{}
+	args := []interface{}{}
+
+	for _, field := range m.filterFields {
+		name := filterPrefix + field.Attrs().Name
+		colName := m.admin.quote(m.tableName, field.Attrs().ColumnName)
+		val := values.Get(name)
+
+		switch field.(type) {
+		case *fields.BooleanField:
+			if b, err := strconv.ParseBool(val); err == nil {
+				conds = append(conds, fmt.Sprintf("%v = ?", colName))
+				args = append(args, b)
+			}
+		case *fields.ForeignKeyField:
+			if len(val) > 0 {
+				conds = append(conds, fmt.Sprintf("%v = ?", colName))
+				args = append(args, val)
+			}
+		case *fields.TimeField:
+			// Compared as times, not however the driver formats them
+			timeCol, timeArg := m.admin.dialect.Time(colName), m.admin.dialect.Time("?")
+			if start, end, ok := dateRange(val, time.Now()); ok {
+				conds = append(conds, fmt.Sprintf("%v >= %v AND %v < %v", timeCol, timeArg, timeCol, timeArg))
+				args = append(args, start.Format(filterTimeLayout), end.Format(filterTimeLayout))
+			} else if val == "custom" {
+				if from, err := time.Parse("2006-01-02", values.Get(name+".from")); err == nil {
+					conds = append(conds, fmt.Sprintf("%v >= %v", timeCol, timeArg))
+					args = append(args, from.Format(filterTimeLayout))
+				}
+				if to, err := time.Parse("2006-01-02", values.Get(name+".to")); err == nil {
+					conds = append(conds, fmt.Sprintf("%v < %v", timeCol, timeArg))
+					args = append(args, to.AddDate(0, 0, 1).Format(filterTimeLayout))
+				}
+			}
+		case *fields.IntField, *fields.FloatField:
+			if min, err := strconv.ParseFloat(values.Get(name+".min"), 64); err == nil {
+				conds = append(conds, fmt.Sprintf("%v >= ?", colName))
+				args = append(args, min)
+			}
+			if max, err := strconv.ParseFloat(values.Get(name+".max"), 64); err == nil {
+				conds = append(conds, fmt.Sprintf("%v <= ?", colName))
+				args = append(args, max)
+			}
+		}
+	}
+
+	return conds, args
+}
+
+// filterViews builds the filter sidebar. Links keep the current search, sorting and other filters, but go back to the
+// first page.
+func (m *model) filterViews(values url.Values) ([]*filterView, error) {
+	views := []*filterView{}
+	for _, field := range m.filterFields {
+		name := filterPrefix + field.Attrs().Name
+		view := &filterView{Label: field.Attrs().Label, Name: name}
+
+		switch f := field.(type) {
+		case *fields.BooleanField:
+			view.Options = []*filterOption{
+				filterLink(values, "All", name, ""),
+				filterLink(values, "Yes", name, "true"),
+				filterLink(values, "No", name, "false"),
+			}
+		case *fields.ForeignKeyField:
+			view.Options = []*filterOption{filterLink(values, "All", name, "")}
+			choices, err := m.admin.relatedChoices(f)
+			if err != nil {
+				return nil, err
+			}
+			for _, choice := range choices {
+				view.Options = append(view.Options, filterLink(values, choice[1], name, choice[0]))
+			}
+		case *fields.TimeField:
+			view.Options = []*filterOption{
+				filterLink(values, "Any date", name, ""),
+				filterLink(values, "Today", name, "today"),
+				filterLink(values, "Past 7 days", name, "week"),
+				filterLink(values, "This month", name, "month"),
+			}
+			view.Range = true
+			view.Type = "date"
+			view.MinName, view.MinValue = name+".from", values.Get(name+".from")
+			view.MaxName, view.MaxValue = name+".to", values.Get(name+".to")
+			view.Hidden = filterHidden(values, name, view.MinName, view.MaxName)
+			view.Hidden.Set(name, "custom")
+		case *fields.IntField, *fields.FloatField:
+			view.Range = true
+			view.Type = "number"
+			view.MinName, view.MinValue = name+".min", values.Get(name+".min")
+			view.MaxName, view.MaxValue = name+".max", values.Get(name+".max")
+			view.Hidden = filterHidden(values, view.MinName, view.MaxName)
+		default:
+			continue
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// filterHidden copies the current query, except for the page number and the given keys.
+func filterHidden(values url.Values, remove ...string) url.Values {
+	hidden := url.Values{}
+	for key, vals := range values {
+		hidden[key] = vals
+	}
+	hidden.Del("page")
+	for _, key := range remove {
+		hidden.Del(key)
+	}
+	return hidden
+}
+
+// filterLink returns an option that sets key to val (or removes it if val is empty) in the current query.
+func filterLink(values url.Values, label, key, val string) *filterOption {
+	query := filterHidden(values, key, key+".from", key+".to")
+	if len(val) > 0 {
+		query.Set(key, val)
+	}
+	return &filterOption{
+		Label:  label,
+		URL:    template.URL("?" + query.Encode()),
+		Active: values.Get(key) == val,
+	}
+}
+
+// relatedChoices returns id and display value of all rows a ForeignKeyField can point to.
+func (a *Admin) relatedChoices(field fields.RelationalField) ([][2]string, error) {
+	pk := a.relatedPK(field)
+	display := pk
+	if len(field.GetListColumn()) > 0 {
+		display = field.GetListColumn()
+	}
+
+	display = a.quote(display)
+	q := a.dialect.Queryf("SELECT %v, %v FROM %v ORDER BY %v", a.quote(pk), display, a.quote(field.GetRelatedTable()), display)
+	rows, err := a.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	choices := [][2]string{}
+	for rows.Next() {
+		var id, label interface{}
+		err = rows.Scan(&id, &label)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := id.([]byte); ok {
+			id = string(b)
+		}
+		if b, ok := label.([]byte); ok {
+			label = string(b)
+		}
+		choices = append(choices, [2]string{fmt.Sprint(id), fmt.Sprint(label)})
+	}
+	return choices, rows.Err()
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/oal/admin/fields"
 )
 
 type route struct {
@@ -79,7 +81,7 @@ func (u *urlConfig) URL(name string, args ...interface{}) (string, error) {
 	for i, part := range route.path {
 		buf.WriteString(part)
 		if len(args) > i {
-			fmt.Fprint(&buf, args[i])
+			buf.WriteString(url.PathEscape(fmt.Sprint(args[i])))
 		}
 	}
 	return buf.String(), nil
@@ -91,13 +93,24 @@ func (a *Admin) render(rw http.ResponseWriter, req *http.Request, tmpl string, c
 	ctx["title"] = a.Title
 	ctx["path"] = a.path
 	ctx["q"] = req.Form.Get("q")
+	ctx["csrf"] = a.csrfToken(rw, req)
 	if _, ok := ctx["anonymous"]; !ok {
 		ctx["anonymous"] = false
 	}
 
 	sess := a.getUserSession(req)
 	if sess != nil {
-		ctx["messages"] = sess.getMessages()
+		messages := sess.getMessages()
+		ctx["user"] = sess.Username
+		ctx["messages"] = messages
+
+		// Messages are only shown once, so remove them from the stored session
+		if len(messages) > 0 {
+			err := a.Sessions.Save(sess)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
 	}
 	err := templates.ExecuteTemplate(rw, tmpl, ctx)
 	if err != nil {
@@ -106,43 +119,128 @@ func (a *Admin) render(rw http.ResponseWriter, req *http.Request, tmpl string, c
 
 }
 
-// handlerWrapper is used to redirect to index / log in page.
-func (a *Admin) handlerWrapper(h httprouter.Handle) httprouter.Handle {
+// handlerWrapper is used to redirect to index / log in page, and to reject POST requests without a valid CSRF token.
+// If perm is set, users without that permission for the model in the URL's :slug get a 403 Forbidden.
+func (a *Admin) handlerWrapper(h httprouter.Handle, perm Permission) httprouter.Handle {
 	return func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		req = a.withUserSession(req)
 		if a.getUserSession(req) == nil && req.URL.Path != a.path+"/" {
 			http.Redirect(rw, req, a.path, 302)
 			return
 		}
+		if req.Method == "POST" && !a.parseForm(rw, req) {
+			return
+		}
+		if req.Method == "POST" && !a.checkCSRF(req) {
+			http.Error(rw, "Invalid or missing CSRF token. Please go back, reload the page and try again.", 403)
+			return
+		}
+		if model, ok := a.models[params.ByName("slug")]; ok && perm != 0 && a.permissions(req, model)&perm != perm {
+			a.forbidden(rw)
+			return
+		}
 		h(rw, req, params)
 	}
 }
 
+// parseForm parses a POSTed form, limited to MaxRequestSize. Forms that are too large are sent back to the page they
+// were posted from with a message, and false is returned.
+func (a *Admin) parseForm(rw http.ResponseWriter, req *http.Request) bool {
+	if a.MaxRequestSize > 0 {
+		req.Body = http.MaxBytesReader(rw, req.Body, a.MaxRequestSize)
+	}
+
+	var err error
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		err = req.ParseMultipartForm(1024 * 1000)
+	} else {
+		err = req.ParseForm()
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		msg := fmt.Sprintf("The form could not be sent, as it's larger than %v. Please try again with smaller files.", fields.FormatSize(a.MaxRequestSize))
+		sess := a.getUserSession(req)
+		referer, _ := url.Parse(req.Referer())
+		if sess == nil || referer == nil || referer.Host != req.Host || !strings.HasPrefix(referer.Path, a.path+"/") {
+			http.Error(rw, msg, 413)
+			return false
+		}
+		a.addMessage(sess, "warning", msg)
+		http.Redirect(rw, req, referer.RequestURI(), 302)
+		return false
+	} else if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return false
+	}
+	return true
+}
+
 func (a *Admin) handleIndex(rw http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	if a.getUserSession(req) == nil {
+		var loginErr string
 		if req.Method == "POST" {
 			req.ParseForm()
-			ok := a.logIn(rw, req.Form.Get("username"), req.Form.Get("password"))
+			ok, err := a.logIn(rw, req.Form.Get("username"), req.Form.Get("password"))
 			if ok {
 				http.Redirect(rw, req, a.path, 302)
+				return
+			}
+			if err != nil {
+				fmt.Println(err)
 			}
+			loginErr = "Invalid username or password."
 		}
 		a.render(rw, req, "login.html", map[string]interface{}{
 			"anonymous": true,
+			"error":     loginErr,
 		})
 		return
 	}
+
+	// Only show models the user is allowed to view, and hide groups that end up empty
+	groups := []*modelGroup{}
+	perms := map[string]Permission{}
+	for _, group := range a.modelGroups {
+		visible := &modelGroup{Name: group.Name, slug: group.slug, Models: []*model{}}
+		for _, model := range group.Models {
+			perm := a.permissions(req, model)
+			if !perm.CanView() {
+				continue
+			}
+			perms[model.Slug] = perm
+			visible.Models = append(visible.Models, model)
+		}
+		if len(visible.Models) > 0 {
+			groups = append(groups, visible)
+		}
+	}
+
+	// Recent actions, only for models the user can see
+	actions := []*logEntry{}
+	entries, err := a.recentActions(50)
+	if err != nil {
+		fmt.Println(err)
+	}
+	for _, entry := range entries {
+		if _, ok := perms[entry.Model]; ok && len(actions) < 10 {
+			actions = append(actions, entry)
+		}
+	}
+
 	a.render(rw, req, "index.html", map[string]interface{}{
-		"groups": a.modelGroups,
+		"groups":  groups,
+		"perms":   perms,
+		"actions": actions,
+		"models":  a.models,
 	})
 }
 
 func (a *Admin) handleLogout(rw http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	cookie, err := req.Cookie("admin")
+	err := a.logOut(req)
 	if err != nil {
-		return
+		fmt.Println(err)
 	}
-
-	delete(a.sessions, cookie.Value)
 	http.Redirect(rw, req, a.path, 302)
 }
 func (a *Admin) handleList(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -167,44 +265,32 @@ func (a *Admin) handleList(rw http.ResponseWriter, req *http.Request, ps httprou
 
 	// GET parameters
 	req.ParseForm()
-	q := req.Form.Get("q")
-
-	// Sort
-	sortBy := req.Form.Get("sort")
-	if len(sortBy) == 0 {
-		sortBy = model.sort
-	}
-	sortDesc := false
-	if sortBy[0] == '-' {
-		sortBy = sortBy[1:]
-		sortDesc = true
-	}
-
-	if model.fieldByName(sortBy) == nil {
-		sortBy = ""
-	}
-
-	// Page number
-	page, err := strconv.ParseUint(req.Form.Get("page"), 10, 64)
-	if err != nil {
-		page = 1
-	}
+	q, sortBy, sortDesc, page := listParams(model, req)
 
 	// Get data
-	results, rows, err := model.page(int(page), q, sortBy, sortDesc)
+	results, rows, err := model.page(int(page), q, req.Form, sortBy, sortDesc)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
+	// Search and filters are kept in all links, sort in pagination too
+	query := filterHidden(req.Form, "sort")
+	sortQuery := filterHidden(req.Form)
+
 	// Invalid page
 	if len(results) == 0 && page != 1 {
 		sess := a.getUserSession(req)
-		sess.addMessage("warning", "Empty page.")
-		http.Redirect(rw, req, req.URL.Path, 302)
+		a.addMessage(sess, "warning", "Empty page.")
+		http.Redirect(rw, req, req.URL.Path+"?"+sortQuery.Encode(), 302)
 		return
 	}
 
+	filters, err := model.filterViews(req.Form)
+	if err != nil {
+		fmt.Println(err)
+	}
+
 	// Render / format field data
 	strResults := [][]template.HTML{}
 	fields := model.listFields
@@ -228,6 +314,8 @@ func (a *Admin) handleList(rw http.ResponseWriter, req *http.Request, ps httprou
 		tmpl = "list.html"
 	}
 
+	perms := a.permissions(req, model)
+
 	// Page numbers
 	pages := make([]int, int(float64(rows)/25.0+0.5))
 
@@ -249,9 +337,45 @@ func (a *Admin) handleList(rw http.ResponseWriter, req *http.Request, ps httprou
 		"numPages": len(pages),
 		"pages":    pages,
 		"rows":     rows,
+
+		"perms":   perms,
+		"actions": model.allowedActions(perms),
+
+		"filters":   filters,
+		"query":     template.URL(query.Encode()),
+		"sortQuery": template.URL(sortQuery.Encode()),
+		"hidden":    filterHidden(req.Form, "q"),
 	})
 }
 
+// listParams returns the search string, sort field and direction, and page number from a parsed list view request.
+func listParams(model *model, req *http.Request) (string, string, bool, uint64) {
+	q := req.Form.Get("q")
+
+	// Sort
+	sortBy := req.Form.Get("sort")
+	if len(sortBy) == 0 {
+		sortBy = model.sort
+	}
+	sortDesc := false
+	if len(sortBy) > 0 && sortBy[0] == '-' {
+		sortBy = sortBy[1:]
+		sortDesc = true
+	}
+
+	if model.fieldByName(sortBy) == nil {
+		sortBy = ""
+	}
+
+	// Page number
+	page, err := strconv.ParseUint(req.Form.Get("page"), 10, 64)
+	if err != nil || page == 0 {
+		page = 1
+	}
+
+	return q, sortBy, sortDesc, page
+}
+
 func (a *Admin) handleEdit(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var data map[string]interface{}
 	var errors map[string]string
@@ -271,20 +395,12 @@ func (a *Admin) handleEdit(rw http.ResponseWriter, req *http.Request, ps httprou
 	}
 
 	// Get ID if we're editing something
-	var id int
-	if idStr := ps.ByName("id"); len(idStr) > 0 {
-		id64, err := strconv.ParseInt(idStr, 10, 64)
-		id = int(id64)
-		if err != nil {
-			http.NotFound(rw, req)
-			return
-		}
-	}
+	id := ps.ByName("id")
 
 	// If no errors / not yet submitted for validation, and we're editing, get data from db
-	if errors == nil && id != 0 {
+	if errors == nil && id != "" {
 		var err error
-		data, err = model.get(id)
+		data, err = model.get(a.db, id)
 		if err != nil {
 			http.NotFound(rw, req)
 			return
@@ -293,22 +409,25 @@ func (a *Admin) handleEdit(rw http.ResponseWriter, req *http.Request, ps httprou
 
 	// Render form and template
 	var buf bytes.Buffer
-	model.renderForm(&buf, data, id == 0, errors)
+	model.renderForm(&buf, data, id == "", errors)
+
+	inlines, err := a.inlineViews(req, model, id, errors)
+	if err != nil {
+		fmt.Println(err)
+	}
 
 	a.render(rw, req, "edit.html", map[string]interface{}{
-		"id":   id,
-		"name": model.Name,
-		"slug": model.Slug,
-		"form": template.HTML(buf.String()),
+		"id":      id,
+		"name":    model.Name,
+		"slug":    model.Slug,
+		"form":    template.HTML(buf.String()),
+		"inlines": inlines,
+		"perms":   a.permissions(req, model),
 	})
 }
 
 func (a *Admin) handleSave(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) (map[string]interface{}, map[string]string) {
-	err := req.ParseMultipartForm(1024 * 1000)
-	if err != nil {
-		return nil, nil
-	}
-
+	// The form has been parsed by handlerWrapper
 	slug := ps.ByName("slug")
 	model, ok := a.models[slug]
 	if !ok {
@@ -316,18 +435,20 @@ func (a *Admin) handleSave(rw http.ResponseWriter, req *http.Request, ps httprou
 		return nil, nil
 	}
 
-	id := 0
-	if idStr := ps.ByName("id"); len(idStr) > 0 {
-		id, err = parseInt(idStr)
-		if err != nil {
-			return nil, nil
+	id := ps.ByName("id")
+
+	// Inlines the user can't edit are shown read only, and never saved
+	inlines := []*inline{}
+	for _, inl := range model.inlines {
+		if a.permissions(req, inl.model) == PermAll {
+			inlines = append(inlines, inl)
 		}
 	}
 
 	sess := a.getUserSession(req)
-	data, dataErrors, err := model.save(id, req)
+	id, data, dataErrors, err := model.save(sess.Username, id, req, inlines)
 	if err != nil {
-		sess.addMessage("warning", err.Error())
+		a.addMessage(sess, "warning", err.Error())
 
 		// Error && data == nil means no changes were made
 		if data == nil {
@@ -336,7 +457,7 @@ func (a *Admin) handleSave(rw http.ResponseWriter, req *http.Request, ps httprou
 		}
 		return data, dataErrors
 	} else {
-		sess.addMessage("success", fmt.Sprintf("%v has been saved.", model.Name))
+		a.addMessage(sess, "success", fmt.Sprintf("%v has been saved.", model.Name))
 		if req.Form.Get("done") == "true" {
 			url, _ := a.urls.URL("view", slug)
 			http.Redirect(rw, req, url, 302)
@@ -348,7 +469,7 @@ func (a *Admin) handleSave(rw http.ResponseWriter, req *http.Request, ps httprou
 	}
 }
 
-func (a *Admin) handleDelete(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+func (a *Admin) handleAction(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	slug := ps.ByName("slug")
 	model, ok := a.models[slug]
 	if !ok {
@@ -356,21 +477,125 @@ func (a *Admin) handleDelete(rw http.ResponseWriter, req *http.Request, ps httpr
 		return
 	}
 
-	id := 0
-	if idStr := ps.ByName("id"); len(idStr) > 0 {
-		var err error
-		id, err = parseInt(idStr)
+	req.ParseForm()
+	act := model.action(req.Form.Get("action"))
+	if act == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	// Delete needs delete permission, custom actions need change permission
+	perms := a.permissions(req, model)
+	if (act == deleteAction && !perms.CanDelete()) || (act != deleteAction && !perms.CanChange()) {
+		a.forbidden(rw)
+		return
+	}
+
+	sess := a.getUserSession(req)
+	listURL, _ := a.urls.URL("view", slug)
+
+	ids := []string{}
+	for _, id := range req.Form["selected_id"] {
+		if len(id) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		a.addMessage(sess, "warning", "No rows were selected.")
+		http.Redirect(rw, req, listURL, 302)
+		return
+	}
+
+	if act != deleteAction {
+		err := model.runAction(sess.Username, act, ids)
 		if err != nil {
-			return
+			a.addMessage(sess, "warning", err.Error())
+		} else {
+			a.addMessage(sess, "success", fmt.Sprintf("%v was run on %v %v.", act.Name, len(ids), model.Name))
+		}
+		http.Redirect(rw, req, listURL, 302)
+		return
+	}
+
+	// Show what will be deleted, and ask for confirmation before deleting
+	if req.Form.Get("confirm") != "yes" {
+		columns := []string{}
+		for _, field := range model.listFields {
+			columns = append(columns, field.Attrs().Label)
+		}
+
+		rows := [][]template.HTML{}
+		for _, id := range ids {
+			data, err := model.get(a.db, id)
+			if err != nil {
+				continue
+			}
+
+			row := []template.HTML{}
+			for _, field := range model.listFields {
+				row = append(row, field.RenderString(data[field.Attrs().Name]))
+			}
+			rows = append(rows, row)
 		}
+
+		a.render(rw, req, "action.html", map[string]interface{}{
+			"name":    model.Name,
+			"slug":    slug,
+			"action":  act,
+			"ids":     ids,
+			"columns": columns,
+			"results": rows,
+		})
+		return
+	}
+
+	err := model.deleteMany(sess.Username, ids)
+	if err != nil {
+		a.addMessage(sess, "warning", err.Error())
+	} else {
+		a.addMessage(sess, "success", fmt.Sprintf("%v %v has been deleted.", len(ids), model.Name))
+	}
+	http.Redirect(rw, req, listURL, 302)
+}
+
+func (a *Admin) handleHistory(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	slug := ps.ByName("slug")
+	model, ok := a.models[slug]
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	id := ps.ByName("id")
+	entries, err := model.history(id)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	a.render(rw, req, "history.html", map[string]interface{}{
+		"id":      id,
+		"name":    model.Name,
+		"slug":    model.Slug,
+		"entries": entries,
+	})
+}
+
+func (a *Admin) handleDelete(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	slug := ps.ByName("slug")
+	model, ok := a.models[slug]
+	if !ok {
+		http.NotFound(rw, req)
+		return
 	}
 
-	err := model.delete(id)
+	id := ps.ByName("id")
 	sess := a.getUserSession(req)
+	err := model.delete(sess.Username, id)
 	if err == nil {
-		sess.addMessage("success", fmt.Sprintf("%v has been deleted.", model.Name))
+		a.addMessage(sess, "success", fmt.Sprintf("%v has been deleted.", model.Name))
 	} else {
-		sess.addMessage("warning", err.Error())
+		a.addMessage(sess, "warning", err.Error())
 	}
 
 	url, _ := a.urls.URL("view", slug)
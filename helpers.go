@@ -2,6 +2,7 @@ package admin
 
 import (
 	"crypto/rand"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
@@ -83,6 +84,15 @@ func randString(n int) string {
 	return string(bytes)
 }
 
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
 func typeToName(t reflect.Type) string {
 	parts := strings.Split(t.String(), ".")
 	return parts[len(parts)-1]
@@ -0,0 +1,357 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/oal/admin/db"
+	"github.com/oal/admin/fields"
+)
+
+// importRow is a single row of an import, with the errors found when validating it.
+type importRow struct {
+	Line      int
+	Id        string
+	Values    []string
+	Errors    []string
+	Unchanged bool
+}
+
+// parseImport reads an uploaded CSV or JSON file into form values for each row. CSV files must start with a header
+// row, and JSON files must contain a list of objects. Columns are matched to fields by name or label, ignoring case,
+// and the names of columns that don't match any field are returned.
+func (m *model) parseImport(filename string, r io.Reader) ([]url.Values, []string, error) {
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		return m.parseImportJSON(r)
+	}
+	return m.parseImportCSV(r)
+}
+
+func (m *model) parseImportCSV(r io.Reader) ([]url.Values, []string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("The file is empty.")
+	}
+
+	// Spreadsheets often start the file with a byte order mark
+	header := records[0]
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	columns := make([]fields.Field, len(header))
+	unknown := []string{}
+	for i, name := range header {
+		columns[i] = m.importField(name)
+		if columns[i] == nil {
+			unknown = append(unknown, name)
+		}
+	}
+
+	rows := []url.Values{}
+	for _, record := range records[1:] {
+		values := url.Values{}
+		for i, val := range record {
+			if i < len(columns) && columns[i] != nil {
+				values.Set(columns[i].Attrs().Name, csvUnescape(val))
+			}
+		}
+		rows = append(rows, values)
+	}
+	return rows, unknown, nil
+}
+
+func (m *model) parseImportJSON(r io.Reader) ([]url.Values, []string, error) {
+	objects := []map[string]interface{}{}
+	err := json.NewDecoder(r).Decode(&objects)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := []url.Values{}
+	unknown := []string{}
+	seen := map[string]bool{}
+	for _, obj := range objects {
+		values := url.Values{}
+		for key, val := range obj {
+			field := m.importField(key)
+			if field == nil {
+				if !seen[key] {
+					seen[key] = true
+					unknown = append(unknown, key)
+				}
+				continue
+			}
+			values.Set(field.Attrs().Name, apiFormValue(val))
+		}
+		rows = append(rows, values)
+	}
+	return rows, unknown, nil
+}
+
+// importField finds the field an import column belongs to.
+func (m *model) importField(column string) fields.Field {
+	column = strings.TrimSpace(column)
+	for _, field := range m.fields {
+		if strings.EqualFold(field.Attrs().Name, column) || strings.EqualFold(field.Attrs().Label, column) {
+			return field
+		}
+	}
+	return nil
+}
+
+// importColumns returns the fields that have a value in at least one of the rows, in the model's order.
+func (m *model) importColumns(rows []url.Values) []fields.Field {
+	columns := []fields.Field{}
+	for _, field := range m.fields {
+		for _, values := range rows {
+			if _, ok := values[field.Attrs().Name]; ok {
+				columns = append(columns, field)
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// runImport saves all rows through saveTx in a single transaction. Rows with an id update the existing row, and fields
+// missing from them keep their current value. Unless commit is true (and no row has errors) the transaction is rolled
+// back, which gives a preview of what an import would do. AfterSave hooks and file cleanups only run once the
+// transaction is committed, so a preview has no effects outside the database besides BeforeSave hooks.
+//
+// Every row is saved within a savepoint, which is rolled back if the row fails. Otherwise a failed statement would leave
+// the transaction aborted on Postgres, and every later row would fail too.
+func (m *model) runImport(username string, rows []url.Values, perm Permission, commit bool) ([]*importRow, bool, error) {
+	tx, err := m.admin.begin()
+	if err != nil {
+		return nil, false, err
+	}
+
+	columns := m.importColumns(rows)
+	idName := m.fieldNames[0]
+	results := []*importRow{}
+	hasErrors := false
+	for i, values := range rows {
+		row := &importRow{Line: i + 1}
+		for _, field := range columns {
+			row.Values = append(row.Values, values.Get(field.Attrs().Name))
+		}
+		results = append(results, row)
+
+		rowErrors, err := m.importRow(tx, username, row, values, idName, perm)
+		if err != nil {
+			tx.rollback()
+			return nil, false, err
+		}
+		if rowErrors {
+			hasErrors = true
+		}
+	}
+
+	if !commit || hasErrors {
+		return results, !hasErrors, tx.rollback()
+	}
+	return results, true, tx.commit()
+}
+
+// importRow saves a single row of an import within a savepoint, and returns whether it has errors.
+func (m *model) importRow(tx *txn, username string, row *importRow, values url.Values, idName string, perm Permission) (bool, error) {
+	_, err := tx.Exec("SAVEPOINT import_row")
+	if err != nil {
+		return false, err
+	}
+	afterCommit := len(tx.afterCommit)
+	uploads := len(tx.uploads)
+	failed := func() (bool, error) {
+		tx.afterCommit = tx.afterCommit[:afterCommit]
+		tx.uploads = tx.uploads[:uploads]
+		_, err := tx.Exec("ROLLBACK TO SAVEPOINT import_row")
+		return true, err
+	}
+
+	// Rows with the id of an existing row update it. Keys that aren't generated by the database can also be given
+	// for new rows.
+	form := url.Values{}
+	if key := values.Get(idName); len(key) > 0 {
+		if existing, err := m.get(tx, key); err == nil {
+			row.Id = key
+			if !perm.CanChange() {
+				row.Errors = append(row.Errors, "You don't have permission to change existing rows.")
+			} else {
+				form = m.formValues(existing)
+			}
+		} else if m.autoKey {
+			row.Errors = append(row.Errors, fmt.Sprintf("%v with id %v doesn't exist.", m.Name, key))
+		} else if !perm.CanAdd() {
+			row.Errors = append(row.Errors, "You don't have permission to add rows.")
+		}
+	} else if !perm.CanAdd() {
+		row.Errors = append(row.Errors, "You don't have permission to add rows.")
+	}
+	for key, vals := range values {
+		form[key] = vals
+	}
+	row.Errors = append(row.Errors, m.resolveRelated(tx, form)...)
+
+	if len(row.Errors) > 0 {
+		return failed()
+	}
+
+	_, _, dataErrors, err := m.saveTx(tx, username, row.Id, &http.Request{Form: form}, nil)
+	if _, ok := err.(noChangesError); ok {
+		row.Unchanged = true
+	}
+	for _, fieldName := range m.fieldNames {
+		if msg, ok := dataErrors[fieldName]; ok {
+			row.Errors = append(row.Errors, fmt.Sprintf("%v: %v", m.fieldByName(fieldName).Attrs().Label, msg))
+		}
+	}
+	if err != nil && !row.Unchanged && len(dataErrors) == 0 {
+		row.Errors = append(row.Errors, err.Error())
+	}
+	if len(row.Errors) > 0 {
+		return failed()
+	}
+
+	_, err = tx.Exec("RELEASE SAVEPOINT import_row")
+	return false, err
+}
+
+// resolveRelated replaces the display values (as exported from the list view) of relational fields with ids. Values
+// that are already ids are kept as they are.
+func (m *model) resolveRelated(q db.Queryer, form url.Values) []string {
+	errs := []string{}
+	for _, field := range m.fields {
+		relField, ok := field.(fields.RelationalField)
+		if !ok || len(relField.GetListColumn()) == 0 {
+			continue
+		}
+
+		name := field.Attrs().Name
+		val := strings.TrimSpace(form.Get(name))
+		if len(val) == 0 {
+			continue
+		}
+
+		// ManyToManyFields have a comma separated list
+		parts := []string{val}
+		if _, ok := field.(*fields.ManyToManyField); ok {
+			parts = strings.Split(val, ",")
+		}
+
+		ids := []string{}
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+			// Generated keys are numbers, and looking up anything else would fail on Postgres.
+			related, ok := m.admin.models[relField.GetModelSlug()]
+			if _, err := strconv.Atoi(part); ok && (err == nil || !related.autoKey) {
+				if _, err := related.get(q, part); err == nil {
+					ids = append(ids, part)
+					continue
+				}
+			}
+
+			query := m.admin.dialect.Queryf("SELECT %v FROM %v WHERE %v = ?", m.admin.quote(m.admin.relatedPK(relField)),
+				m.admin.quote(relField.GetRelatedTable()), m.admin.quote(relField.GetListColumn()))
+			var id string
+			err := q.QueryRow(query, part).Scan(&id)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v doesn't exist.", field.Attrs().Label, part))
+				continue
+			}
+			ids = append(ids, id)
+		}
+		form.Set(name, strings.Join(ids, ","))
+	}
+	return errs
+}
+
+func (a *Admin) handleImport(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	slug := ps.ByName("slug")
+	model, ok := a.models[slug]
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	perms := a.permissions(req, model)
+	if !perms.CanAdd() && !perms.CanChange() {
+		a.forbidden(rw)
+		return
+	}
+
+	ctx := map[string]interface{}{
+		"name":    model.Name,
+		"slug":    slug,
+		"columns": model.fields,
+	}
+	if req.Method != "POST" {
+		a.render(rw, req, "import.html", ctx)
+		return
+	}
+
+	// Either a new upload to preview, or previewed data to import
+	var rows []url.Values
+	var unknown []string
+	sess := a.getUserSession(req)
+	data := req.PostFormValue("data")
+	if len(data) > 0 {
+		err := json.Unmarshal([]byte(data), &rows)
+		if err != nil {
+			http.Error(rw, err.Error(), 400)
+			return
+		}
+	} else {
+		file, header, err := req.FormFile("file")
+		if err != nil {
+			a.addMessage(sess, "warning", "Please choose a CSV or JSON file to import.")
+			importURL, _ := a.urls.URL("import", slug)
+			http.Redirect(rw, req, importURL, 302)
+			return
+		}
+		defer file.Close()
+
+		rows, unknown, err = model.parseImport(header.Filename, file)
+		if err != nil {
+			a.addMessage(sess, "warning", fmt.Sprintf("Could not read %v: %v", header.Filename, err))
+			importURL, _ := a.urls.URL("import", slug)
+			http.Redirect(rw, req, importURL, 302)
+			return
+		}
+	}
+
+	commit := len(data) > 0
+	results, ok, err := model.runImport(sess.Username, rows, perms, commit)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if commit && ok && err == nil {
+		a.addMessage(sess, "success", fmt.Sprintf("%v %v has been imported.", len(rows), model.Name))
+		listURL, _ := a.urls.URL("view", slug)
+		http.Redirect(rw, req, listURL, 302)
+		return
+	}
+
+	ctx["preview"] = true
+	ctx["ok"] = ok && err == nil
+	encoded, err := json.Marshal(rows)
+	if err != nil {
+		fmt.Println(err)
+	}
+	ctx["columns"] = model.importColumns(rows)
+	ctx["unknown"] = unknown
+	ctx["rows"] = results
+	ctx["data"] = string(encoded)
+	a.render(rw, req, "import.html", ctx)
+}
@@ -0,0 +1,253 @@
+package admin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/oal/admin/fields"
+)
+
+// inline is a model edited on the edit page of the model its ForeignKeyField points to.
+type inline struct {
+	model *model
+	field *fields.ForeignKeyField
+}
+
+// inlineView is what edit.html needs to render an inline.
+type inlineView struct {
+	Name           string
+	Slug           string
+	CountName      string
+	Editable       bool
+	IdName         string
+	Columns        []string
+	Rows           []*inlineRow
+	Template       template.HTML
+	TemplatePrefix string
+}
+
+// inlineRow is a row of an inline. New rows are only saved once admin.js has marked them as changed, so rows added and
+// left as they are aren't saved with their default values.
+type inlineRow struct {
+	Prefix  string
+	Id      string
+	Changed bool
+	Form    template.HTML
+	Values  []template.HTML
+}
+
+// RegisterInline shows the rows of child pointing to this model on its edit page, where they can be added, changed and
+// removed together with it. child must have a ForeignKeyField pointing to this model.
+func (m *Model) RegisterInline(child *Model) error {
+	return m.model.registerInline(child.model)
+}
+
+func (m *model) registerInline(child *model) error {
+	for _, field := range child.fields {
+		if fk, ok := field.(*fields.ForeignKeyField); ok && fk.GetRelatedTable() == m.tableName {
+			m.inlines = append(m.inlines, &inline{child, fk})
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("%v has no foreign key to %v.", child.Name, m.Name))
+}
+
+// prefix is added to the names of an inline row's form fields.
+func (inl *inline) prefix(i interface{}) string {
+	return fmt.Sprintf("%v-%v-", inl.model.Slug, i)
+}
+
+func (inl *inline) countName() string {
+	return inl.model.Slug + "-count"
+}
+
+// ids returns the ids of the child rows pointing to parentId.
+func (inl *inline) ids(parentId string) ([]string, error) {
+	child := inl.model
+	q := child.admin.dialect.Queryf("SELECT %v FROM %v WHERE %v = ? ORDER BY %v", child.admin.quote(child.pkColumn()),
+		child.admin.quote(child.tableName), child.admin.quote(inl.field.Attrs().ColumnName), child.admin.quote(child.pkColumn()))
+	rows, err := child.admin.db.Query(q, parentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// inlineViews renders the inlines of the object with the given id. After a failed save the posted rows are shown again
+// along with their errors, instead of the stored ones. Only users with all permissions for a child model can edit it.
+func (a *Admin) inlineViews(req *http.Request, m *model, id string, errors map[string]string) ([]*inlineView, error) {
+	views := []*inlineView{}
+	for _, inl := range m.inlines {
+		child := inl.model
+		perm := a.permissions(req, child)
+		if !perm.CanView() {
+			continue
+		}
+
+		view := &inlineView{
+			Name:      child.Name,
+			Slug:      child.Slug,
+			CountName: inl.countName(),
+			Editable:  perm == PermAll,
+			IdName:    child.fieldNames[0],
+		}
+		for _, fieldName := range child.fieldNames[1:] {
+			if field := child.fieldByName(fieldName); field != inl.field {
+				view.Columns = append(view.Columns, field.Attrs().Label)
+			}
+		}
+
+		// Rows as posted, or from the database
+		rows := []map[string]interface{}{}
+		if count, err := parseInt(req.Form.Get(inl.countName())); err == nil && errors != nil && view.Editable {
+			for i := 0; i < count; i++ {
+				data := map[string]interface{}{}
+				for _, fieldName := range child.fieldNames {
+					data[fieldName] = req.Form.Get(inl.prefix(i) + fieldName)
+				}
+				rows = append(rows, data)
+			}
+		} else if id != "" {
+			ids, err := inl.ids(id)
+			if err != nil {
+				return nil, err
+			}
+			for _, childId := range ids {
+				data, err := child.get(child.admin.db, childId)
+				if err != nil {
+					return nil, err
+				}
+				rows = append(rows, data)
+			}
+		}
+
+		for i, data := range rows {
+			row := &inlineRow{Prefix: inl.prefix(i), Changed: req.Form.Get(inl.prefix(i)+"CHANGED") == "true"}
+			if childId := data[child.fieldNames[0]]; childId != nil {
+				row.Id = fmt.Sprint(childId)
+			}
+			if view.Editable {
+				var buf bytes.Buffer
+				child.renderFields(&buf, row.Prefix, data, false, errors, inl.field)
+				row.Form = template.HTML(buf.String())
+			} else {
+				for _, fieldName := range child.fieldNames[1:] {
+					if field := child.fieldByName(fieldName); field != inl.field {
+						row.Values = append(row.Values, field.RenderString(data[fieldName]))
+					}
+				}
+			}
+			view.Rows = append(view.Rows, row)
+		}
+
+		// Blank row, copied by admin.js when adding rows
+		if view.Editable {
+			var buf bytes.Buffer
+			child.renderFields(&buf, inl.prefix("__prefix__"), nil, true, nil, inl.field)
+			view.Template = template.HTML(buf.String())
+			view.TemplatePrefix = inl.prefix("__prefix__")
+		}
+
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// saveInlines saves the rows of the given inlines posted along with the parent object. Errors are returned keyed by the
+// inline rows' form field names, so they can be shown next to the fields.
+func (m *model) saveInlines(tx *txn, username string, parentId string, req *http.Request, inlines []*inline) (bool, map[string]string, error) {
+	changed := false
+	inlineErrors := map[string]string{}
+	for _, inl := range inlines {
+		child := inl.model
+		count, err := parseInt(req.Form.Get(inl.countName()))
+		if err != nil {
+			// Inline wasn't part of the form
+			continue
+		}
+
+		for i := 0; i < count; i++ {
+			prefix := inl.prefix(i)
+
+			childId := req.Form.Get(prefix + child.fieldNames[0])
+			if len(childId) > 0 {
+				// Only rows that belong to the parent can be changed from its page
+				existing, err := child.get(tx, childId)
+				if err != nil {
+					return changed, nil, err
+				}
+				if fmt.Sprint(existing[inl.field.Attrs().Name]) != parentId {
+					return changed, nil, errors.New(fmt.Sprintf("%v %v doesn't belong to this %v.", child.Name, childId, m.Name))
+				}
+			}
+
+			if req.Form.Get(prefix+"DELETE") == "true" {
+				if childId != "" {
+					err = child.deleteTx(tx, username, childId)
+					if err != nil {
+						return changed, nil, err
+					}
+					changed = true
+				}
+				continue
+			}
+
+			// Copy the row's values and files to a request of its own, without the prefix
+			form := url.Values{}
+			files := map[string][]*multipart.FileHeader{}
+			empty := true
+			for _, fieldName := range child.fieldNames[1:] {
+				if vals, ok := req.Form[prefix+fieldName]; ok {
+					form[fieldName] = vals
+					empty = empty && len(strings.Join(vals, "")) == 0
+				}
+				if vals, ok := req.Form[prefix+fieldName+"-clear"]; ok {
+					form[fieldName+"-clear"] = vals
+				}
+				if req.MultipartForm != nil {
+					if fileHeaders, ok := req.MultipartForm.File[prefix+fieldName]; ok {
+						files[fieldName] = fileHeaders
+						empty = false
+					}
+				}
+			}
+
+			// New rows that haven't been touched, or were left blank, are skipped
+			if childId == "" && (empty || req.Form.Get(prefix+"CHANGED") != "true") {
+				continue
+			}
+
+			form.Set(inl.field.Attrs().Name, parentId)
+			childReq := &http.Request{Form: form, MultipartForm: &multipart.Form{File: files}}
+			_, _, childErrors, err := child.saveTx(tx, username, childId, childReq, nil)
+			if _, ok := err.(noChangesError); ok {
+				continue
+			}
+			for fieldName, msg := range childErrors {
+				inlineErrors[prefix+fieldName] = msg
+			}
+			if err != nil && len(childErrors) == 0 {
+				return changed, nil, err
+			}
+			changed = true
+		}
+	}
+	return changed, inlineErrors, nil
+}
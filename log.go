@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const logTable = "admin_log"
+
+// Actions stored in the audit log.
+const (
+	logCreate = "create"
+	logUpdate = "update"
+	logDelete = "delete"
+)
+
+// logEntry is a single create, update or delete done through the admin.
+type logEntry struct {
+	Username string
+	Model    string
+	ObjectId string
+	Action   string
+	Time     time.Time
+	Changes  []*fieldChange
+}
+
+// fieldChange is the before / after value of a single field. Before is nil for new objects, and After is nil for
+// deleted ones.
+type fieldChange struct {
+	Field  string      `json:"field"`
+	Label  string      `json:"label"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+func (a *Admin) setupLog() error {
+	q := a.dialect.Queryf(`CREATE TABLE IF NOT EXISTS %v (
+		username VARCHAR(255) NOT NULL,
+		model VARCHAR(255) NOT NULL,
+		object_id VARCHAR(255) NOT NULL,
+		action VARCHAR(64) NOT NULL,
+		created BIGINT NOT NULL,
+		changes TEXT NOT NULL
+	)`, logTable)
+	_, err := a.db.Exec(q)
+	return err
+}
+
+// log stores an audit log entry, using ex so it can be part of a transaction.
+func (m *model) log(ex execer, username, id, action string, changes []*fieldChange) error {
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	q := m.admin.dialect.Queryf("INSERT INTO %v (username, model, object_id, action, created, changes) VALUES (?, ?, ?, ?, ?, ?)", logTable)
+	_, err = ex.Exec(q, username, m.Slug, id, action, time.Now().UnixNano(), string(changesJSON))
+	return err
+}
+
+// history returns the audit log for a single object, newest first.
+func (m *model) history(id string) ([]*logEntry, error) {
+	q := m.admin.dialect.Queryf("SELECT username, model, object_id, action, created, changes FROM %v WHERE model = ? AND object_id = ? ORDER BY created DESC", logTable)
+	return m.admin.queryLog(q, m.Slug, id)
+}
+
+// recentActions returns the latest log entries for all models, newest first.
+func (a *Admin) recentActions(limit int) ([]*logEntry, error) {
+	q := a.dialect.Queryf("SELECT username, model, object_id, action, created, changes FROM %v ORDER BY created DESC %v", logTable, a.dialect.Paginate(limit, 0))
+	return a.queryLog(q)
+}
+
+func (a *Admin) queryLog(q string, args ...interface{}) ([]*logEntry, error) {
+	rows, err := a.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*logEntry{}
+	for rows.Next() {
+		entry := &logEntry{}
+		var created int64
+		var changes string
+		err := rows.Scan(&entry.Username, &entry.Model, &entry.ObjectId, &entry.Action, &created, &changes)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Time = time.Unix(0, created)
+		err = json.Unmarshal([]byte(changes), &entry.Changes)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
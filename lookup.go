@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/oal/admin/db"
+	"github.com/oal/admin/fields"
+)
+
+// Number of rows returned by a lookup search
+const lookupLimit = 10
+
+type lookupResult struct {
+	Id   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// handleLookup finds rows for the autocomplete widgets of relational fields. With q, rows are searched by the model's
+// search fields (or the display column if it has none), and with ids, the rows with those ids (comma separated) are
+// returned in the same order. Each row is shown by its display column, which is the list='FieldName' column of the
+// field pointing to the model.
+func (a *Admin) handleLookup(rw http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	model := a.models[ps.ByName("slug")]
+
+	req.ParseForm()
+	display := model.displayField(req.Form.Get("display"))
+
+	var results []*lookupResult
+	var err error
+	if ids := req.Form.Get("ids"); len(ids) > 0 {
+		results, err = model.lookupIds(strings.Split(ids, ","), display)
+	} else {
+		results, err = model.lookup(req.Form.Get("q"), display)
+	}
+	if err != nil {
+		fmt.Println(err)
+		writeJSONError(rw, 500, "Could not look up rows.")
+		return
+	}
+
+	writeJSON(rw, 200, map[string]interface{}{"results": results})
+}
+
+// displayField returns the field with the given column name, or the first listed field after the primary key.
+func (m *model) displayField(column string) fields.Field {
+	for _, field := range m.fields {
+		if _, ok := field.(*fields.ManyToManyField); !ok && len(column) > 0 && field.Attrs().ColumnName == column {
+			return field
+		}
+	}
+	if len(m.listFields) > 1 {
+		if _, ok := m.listFields[1].(*fields.ManyToManyField); !ok {
+			return m.listFields[1]
+		}
+	}
+	return m.fields[0]
+}
+
+// lookup searches for rows matching q, ordered by their display column.
+func (m *model) lookup(search string, display fields.Field) ([]*lookupResult, error) {
+	q := m.admin.quote
+	where, args := m.searchCond(search)
+	if len(where) == 0 && len(strings.TrimSpace(search)) > 0 {
+		where = fmt.Sprintf("%v LIKE ? ESCAPE '!'", m.admin.dialect.Text(q(m.tableName, display.Attrs().ColumnName)))
+		args = []interface{}{"%" + likeEscaper.Replace(strings.TrimSpace(search)) + "%"}
+	}
+	if len(where) > 0 {
+		where = " WHERE " + where
+	}
+
+	query := m.admin.dialect.Queryf("SELECT %v, %v FROM %v%v ORDER BY %v %v", q(m.tableName, m.pkColumn()),
+		q(m.tableName, display.Attrs().ColumnName), q(m.tableName), where, q(m.tableName, display.Attrs().ColumnName),
+		m.admin.dialect.Paginate(lookupLimit, 0))
+	return m.lookupRows(query, args, display)
+}
+
+// lookupIds returns the rows with the given ids, in the same order.
+func (m *model) lookupIds(ids []string, display fields.Field) ([]*lookupResult, error) {
+	args := []interface{}{}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			args = append(args, id)
+		}
+	}
+	if len(args) == 0 {
+		return []*lookupResult{}, nil
+	}
+
+	q := m.admin.quote
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	query := m.admin.dialect.Queryf("SELECT %v, %v FROM %v WHERE %v IN (%v)", q(m.tableName, m.pkColumn()),
+		q(m.tableName, display.Attrs().ColumnName), q(m.tableName), q(m.tableName, m.pkColumn()), marks)
+	rows, err := m.lookupRows(query, args, display)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := map[string]*lookupResult{}
+	for _, row := range rows {
+		byId[row.Id] = row
+	}
+	results := []*lookupResult{}
+	for _, id := range args {
+		if row, ok := byId[id.(string)]; ok {
+			results = append(results, row)
+		}
+	}
+	return results, nil
+}
+
+func (m *model) lookupRows(query string, args []interface{}, display fields.Field) ([]*lookupResult, error) {
+	rows, err := m.admin.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*lookupResult{}
+	for rows.Next() {
+		row, err := db.ScanRow(2, rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &lookupResult{fmt.Sprint(row[0]), fields.RenderText(display, row[1])})
+	}
+	return results, rows.Err()
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"reflect"
+	"strings"
 
 	"github.com/extemporalgenome/slug"
 	_ "github.com/mattn/go-sqlite3"
@@ -28,10 +29,24 @@ type Admin struct {
 	// is used in Go.
 	NameTransform NameTransformFunc
 
+	// Auth is used to check usernames and passwords when logging in. Use User or UserTable to set up one of the
+	// built-in backends, or assign your own Authenticator.
+	Auth Authenticator
+
+	// Sessions stores logged in users' sessions. Defaults to an in-memory store. See SQLSessions for an alternative
+	// that survives restarts.
+	Sessions SessionStore
+
+	// Permissions decides what each user may do with each model. If not set, all users can do everything. See
+	// Grants for a simple role based implementation.
+	Permissions Authorizer
+
+	// MaxRequestSize limits the size of POSTed forms, uploads included, and of JSON API requests, in bytes. Defaults to
+	// DefaultMaxRequestSize.
+	// File fields can be limited further with the max_size tag.
+	MaxRequestSize int64
+
 	path      string
-	username  string
-	password  string
-	sessions  map[string]*session
 	urls      *urlConfig
 	db        *sql.DB
 	dialect   db.Dialect
@@ -43,6 +58,9 @@ type Admin struct {
 	missingRels    map[fields.RelationalField]reflect.Type
 }
 
+// DefaultMaxRequestSize is the MaxRequestSize set by New.
+const DefaultMaxRequestSize = 32 << 20
+
 // New sets up the admin with a "path" prefix (typically /admin) and the name of a database driver and source.
 func New(path, dbDriver, dbSource string) (*Admin, error) {
 	admin := &Admin{}
@@ -55,8 +73,9 @@ func New(path, dbDriver, dbSource string) (*Admin, error) {
 	admin.sourceDir = fmt.Sprintf("%v/src/github.com/oal/admin", os.Getenv("GOPATH"))
 	admin.path = path
 	admin.Title = "Admin"
+	admin.MaxRequestSize = DefaultMaxRequestSize
 
-	admin.sessions = map[string]*session{}
+	admin.Sessions = NewMemorySessionStore(DefaultIdleTimeout, DefaultMaxAge)
 
 	// Model init
 	admin.models = map[string]*model{}
@@ -79,15 +98,30 @@ func (a *Admin) SourceDir(dir string) error {
 	return nil
 }
 
-// User sets username and password for the admin panel. This will change in the future, when support for custom login backends
-// is implemented. No promises on when that will happen, though.
+// User sets a single username and password for the admin panel. For multiple users, see UserTable or Auth.
 func (a *Admin) User(username, password string) error {
 	if len(username) == 0 || len(password) == 0 {
 		return errors.New("Username and/or password is missing")
 	}
 
-	a.username = username
-	a.password = password
+	a.Auth = &staticAuth{username, password}
+
+	return nil
+}
+
+// UserTable lets users log in with credentials stored in a database table. Passwords must be bcrypt hashes, which can
+// be created with HashPassword.
+func (a *Admin) UserTable(table, usernameColumn, passwordColumn string) error {
+	if len(table) == 0 || len(usernameColumn) == 0 || len(passwordColumn) == 0 {
+		return errors.New("Table, username column and password column must all be set")
+	}
+
+	a.Auth = &tableAuth{
+		admin:          a,
+		table:          table,
+		usernameColumn: usernameColumn,
+		passwordColumn: passwordColumn,
+	}
 
 	return nil
 }
@@ -99,6 +133,16 @@ func (a *Admin) Handler() (http.Handler, error) {
 		return nil, err
 	}
 
+	// Audit log table
+	if err := a.setupLog(); err != nil {
+		return nil, err
+	}
+
+	// Only reported, as the check can't know every database's column types
+	if err := a.Check(); err != nil {
+		fmt.Println(err)
+	}
+
 	// Load templates (only once, in case we run multiple admins)
 	if templates == nil {
 		var err error
@@ -120,20 +164,39 @@ func (a *Admin) Handler() (http.Handler, error) {
 	urls.router.RedirectTrailingSlash = true
 	urls.router.RedirectFixedPath = true
 
-	urls.add("index", "GET", "/", a.handlerWrapper(a.handleIndex))
-	urls.add("login", "POST", "/", a.handlerWrapper(a.handleIndex))
-	urls.add("logout", "GET", "/logout/", a.handlerWrapper(a.handleLogout))
+	urls.add("index", "GET", "/", a.handlerWrapper(a.handleIndex, 0))
+	urls.add("login", "POST", "/", a.handlerWrapper(a.handleIndex, 0))
+	urls.add("logout", "POST", "/logout/", a.handlerWrapper(a.handleLogout, 0))
+
+	urls.add("view", "GET", "/view/:slug/", a.handlerWrapper(a.handleList, PermView))
+	urls.add("view2", "GET", "/view/:slug/:view/*multiselect", a.handlerWrapper(a.handleList, PermView))
+
+	urls.add("new", "GET", "/new/:slug/", a.handlerWrapper(a.handleEdit, PermAdd))
+	urls.add("create", "POST", "/create/:slug/", a.handlerWrapper(a.handleEdit, PermAdd))
+
+	urls.add("edit", "GET", "/edit/:slug/:id/", a.handlerWrapper(a.handleEdit, PermView))
+	urls.add("save", "POST", "/save/:slug/:id/", a.handlerWrapper(a.handleEdit, PermChange))
 
-	urls.add("view", "GET", "/view/:slug/", a.handlerWrapper(a.handleList))
-	urls.add("view2", "GET", "/view/:slug/:view/*multiselect", a.handlerWrapper(a.handleList))
+	urls.add("delete", "POST", "/delete/:slug/:id/", a.handlerWrapper(a.handleDelete, PermDelete))
 
-	urls.add("new", "GET", "/new/:slug/", a.handlerWrapper(a.handleEdit))
-	urls.add("create", "POST", "/create/:slug/", a.handlerWrapper(a.handleEdit))
+	urls.add("action", "POST", "/action/:slug/", a.handlerWrapper(a.handleAction, PermView))
 
-	urls.add("edit", "GET", "/edit/:slug/:id/", a.handlerWrapper(a.handleEdit))
-	urls.add("save", "POST", "/save/:slug/:id/", a.handlerWrapper(a.handleEdit))
+	urls.add("export", "GET", "/export/:slug/", a.handlerWrapper(a.handleExport, PermView))
 
-	urls.add("delete", "GET", "/delete/:slug/:id/", a.handlerWrapper(a.handleDelete))
+	urls.add("import", "GET", "/import/:slug/", a.handlerWrapper(a.handleImport, PermView))
+	urls.add("import_run", "POST", "/import/:slug/", a.handlerWrapper(a.handleImport, PermView))
+
+	urls.add("history", "GET", "/history/:slug/:id/", a.handlerWrapper(a.handleHistory, PermView))
+
+	// Autocomplete for relational fields
+	urls.add("lookup", "GET", "/lookup/:slug/", a.apiWrapper(a.handleLookup, PermView))
+
+	// JSON API
+	urls.add("api_list", "GET", "/api/:slug/", a.apiWrapper(a.handleAPIList, PermView))
+	urls.add("api_create", "POST", "/api/:slug/", a.apiWrapper(a.handleAPISave, PermAdd))
+	urls.add("api_get", "GET", "/api/:slug/:id/", a.apiWrapper(a.handleAPIGet, PermView))
+	urls.add("api_update", "PUT", "/api/:slug/:id/", a.apiWrapper(a.handleAPISave, PermChange))
+	urls.add("api_delete", "DELETE", "/api/:slug/:id/", a.apiWrapper(a.handleAPIDelete, PermDelete))
 
 	urls.router.ServeFiles(a.path+"/static/*filepath", http.Dir(staticDir))
 
@@ -169,8 +232,10 @@ func (a *Admin) database(driver, source string) error {
 	switch driver {
 	case "postgres":
 		a.dialect = db.PostgresDialect{}
-	case "sqlite3", "mysql":
-		a.dialect = db.BaseDialect{}
+	case "sqlite3":
+		a.dialect = db.SQLiteDialect{}
+	case "mysql":
+		a.dialect = db.MySQLDialect{}
 	default:
 		return errors.New(fmt.Sprintf("Unknown database driver %v", driver))
 	}
@@ -178,3 +243,12 @@ func (a *Admin) database(driver, source string) error {
 	a.db = adminDB
 	return nil
 }
+
+// quote quotes table and column names for the database in use, and joins them with dots.
+func (a *Admin) quote(names ...string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = a.dialect.Quote(name)
+	}
+	return strings.Join(quoted, ".")
+}
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/extemporalgenome/slug"
 	"github.com/oal/admin/db"
@@ -24,6 +27,29 @@ type SortedModel interface {
 	SortBy() string
 }
 
+// The hooks below are called on a new instance of the registered struct, holding the row they're called for.
+
+// BeforeSaveModel can validate a row, after each field has been validated, before it's saved. Changes made to the struct
+// are saved. The returned errors are keyed by field name and shown next to the fields.
+type BeforeSaveModel interface {
+	BeforeSave() map[string]string
+}
+
+// AfterSaveModel is notified after a row has been saved.
+type AfterSaveModel interface {
+	AfterSave()
+}
+
+// BeforeDeleteModel can prevent a row from being deleted by returning an error, which is shown to the user.
+type BeforeDeleteModel interface {
+	BeforeDelete() error
+}
+
+// AfterDeleteModel is notified after a row has been deleted.
+type AfterDeleteModel interface {
+	AfterDelete()
+}
+
 type modelGroup struct {
 	admin  *Admin
 	Name   string
@@ -31,8 +57,26 @@ type modelGroup struct {
 	Models []*model
 }
 
-// RegisterModel adds a model to a model group.
+// RegisterModel adds a model to a model group. Use Admin.Model to register bulk actions and inlines for it.
 func (g *modelGroup) RegisterModel(mdl interface{}) error {
+	_, err := g.registerModel(mdl)
+	return err
+}
+
+// Model is a registered model. It's used to register bulk actions and inlines, and to load and save rows as structs.
+type Model struct {
+	*model
+}
+
+// Model returns the registered model for mdl's type (like new(BlogPost)), or nil if it hasn't been registered.
+func (a *Admin) Model(mdl interface{}) *Model {
+	if m, ok := a.registeredRels[reflect.TypeOf(mdl)]; ok {
+		return &Model{m}
+	}
+	return nil
+}
+
+func (g *modelGroup) registerModel(mdl interface{}) (*model, error) {
 	modelType := reflect.TypeOf(mdl)
 	ind := reflect.Indirect(reflect.ValueOf(mdl))
 
@@ -43,17 +87,33 @@ func (g *modelGroup) RegisterModel(mdl interface{}) error {
 		name = named.AdminName()
 	}
 
+	beforeSave, _ := mdl.(BeforeSaveModel)
+	afterSave, _ := mdl.(AfterSaveModel)
+	beforeDelete, _ := mdl.(BeforeDeleteModel)
+	afterDelete, _ := mdl.(AfterDeleteModel)
+
 	newModel := model{
-		Name:      name,
-		Slug:      slug.SlugAscii(name),
-		tableName: tableName,
-		fields:    []fields.Field{},
+		Name:       name,
+		Slug:       slug.SlugAscii(name),
+		tableName:  tableName,
+		fields:     []fields.Field{},
+		typ:        modelType.Elem(),
+		fieldIndex: map[string]int{},
 
 		fieldNames:        []string{},
 		listFields:        []fields.Field{},
 		searchableColumns: []string{},
+		filterFields:      []fields.Field{},
+		actions:           []*action{},
+		inlines:           []*inline{},
+
+		beforeSave:   beforeSave,
+		afterSave:    afterSave,
+		beforeDelete: beforeDelete,
+		afterDelete:  afterDelete,
 
 		admin: g.admin,
+		group: g,
 	}
 
 	// Set as registered so it can be used as a ForeignKey from other models
@@ -71,8 +131,24 @@ func (g *modelGroup) RegisterModel(mdl interface{}) error {
 		delete(g.admin.missingRels, field)
 	}
 
-	// Loop over struct fields and set up fields
+	// The primary key is the field tagged pk, or the first one. It's set up first, so it's always the first field.
+	pkIndex := 0
 	for i := 0; i < ind.NumField(); i++ {
+		tagMap, err := parseTag(modelType.Elem().Field(i).Tag.Get("admin"))
+		if _, ok := tagMap["pk"]; ok && err == nil {
+			pkIndex = i
+			break
+		}
+	}
+	order := []int{pkIndex}
+	for i := 0; i < ind.NumField(); i++ {
+		if i != pkIndex {
+			order = append(order, i)
+		}
+	}
+
+	// Loop over struct fields and set up fields
+	for _, i := range order {
 		refl := modelType.Elem().Field(i)
 		fieldType := refl.Type
 		kind := fieldType.Kind()
@@ -86,8 +162,8 @@ func (g *modelGroup) RegisterModel(mdl interface{}) error {
 		// Parse key=val / key options from struct tag, used for configuration later
 		tag := refl.Tag.Get("admin")
 		if tag == "-" {
-			if i == 0 {
-				return errors.New("First column (id) can't be skipped.")
+			if i == pkIndex {
+				return nil, errors.New("The primary key can't be skipped.")
 			}
 			continue
 		}
@@ -96,8 +172,8 @@ func (g *modelGroup) RegisterModel(mdl interface{}) error {
 			panic(err)
 		}
 
-		// ID (i == 0) is always shown
-		if i == 0 {
+		// The primary key is always shown
+		if i == pkIndex {
 			tagMap["list"] = ""
 		}
 
@@ -132,11 +208,14 @@ func (g *modelGroup) RegisterModel(mdl interface{}) error {
 		}
 
 		// Transform struct keys to DB column names if needed
-		var tableField string
+		tableField := fieldName
 		if g.admin.NameTransform != nil {
 			tableField = g.admin.NameTransform(fieldName)
-		} else {
-			tableField = refl.Name
+		}
+
+		// Unless a primary key is tagged, it's expected to be named id
+		if _, ok := tagMap["pk"]; !ok && i == pkIndex {
+			tableField = "id"
 		}
 
 		field.Attrs().Name = fieldName
@@ -145,20 +224,27 @@ func (g *modelGroup) RegisterModel(mdl interface{}) error {
 
 		newModel.fields = append(newModel.fields, field)
 		newModel.fieldNames = append(newModel.fieldNames, fieldName)
+		newModel.fieldIndex[fieldName] = i
+	}
+
+	// Integer keys are generated by the database
+	switch modelType.Elem().Field(pkIndex).Type.Kind() {
+	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		newModel.autoKey = true
 	}
 
 	// Default sorting in list view
 	if sorted, ok := mdl.(SortedModel); ok && newModel.fieldByName(sorted.SortBy()) != nil {
 		newModel.sort = sorted.SortBy()
 	} else {
-		newModel.sort = "-Id"
+		newModel.sort = "-" + newModel.fieldNames[0]
 	}
 
 	g.admin.models[newModel.Slug] = &newModel
 	g.Models = append(g.Models, &newModel)
 
 	fmt.Println("Registered", newModel.Name)
-	return nil
+	return &newModel, nil
 }
 
 func makeField(kind reflect.Kind, override string) fields.Field {
@@ -170,8 +256,7 @@ func makeField(kind reflect.Kind, override string) fields.Field {
 		newField := reflect.New(customType)
 
 		// Create BaseField in Field
-		baseField := newField.Elem().Field(0)
-		baseField.Set(reflect.ValueOf(&fields.BaseField{}))
+		setBaseField(newField.Elem(), &fields.BaseField{})
 
 		field = newField.Interface().(fields.Field)
 	} else {
@@ -220,6 +305,18 @@ func applyFieldTags(mdl *model, field fields.Field, tagMap map[string]string) {
 		field.Attrs().RelationTable = tab
 	}
 
+	if col, ok := tagMap["rel_from"]; ok {
+		field.Attrs().RelationFrom = col
+	}
+
+	if col, ok := tagMap["rel_to"]; ok {
+		field.Attrs().RelationTo = col
+	}
+
+	if col, ok := tagMap["rel_order"]; ok {
+		field.Attrs().RelationOrder = col
+	}
+
 	if _, ok := tagMap["null"]; ok {
 		field.Attrs().Null = true
 	}
@@ -242,6 +339,11 @@ func applyFieldTags(mdl *model, field fields.Field, tagMap map[string]string) {
 		mdl.searchableColumns = append(mdl.searchableColumns, field.Attrs().ColumnName)
 	}
 
+	if _, ok := tagMap["filter"]; ok {
+		field.Attrs().Filter = true
+		mdl.filterFields = append(mdl.filterFields, field)
+	}
+
 	if val, ok := tagMap["default"]; ok {
 		field.Attrs().DefaultValue = val
 	}
@@ -255,26 +357,129 @@ func applyFieldTags(mdl *model, field fields.Field, tagMap map[string]string) {
 	}
 }
 
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// txn is a transaction that also keeps track of the files uploaded as part of it, which are removed again if it fails,
+// and of hooks to run once it's committed.
+type txn struct {
+	*sql.Tx
+	uploads     []upload
+	afterCommit []func()
+}
+
+// upload is a file stored while saving, which is removed again if the transaction fails.
+type upload struct {
+	field fields.FileHandlerField
+	name  string
+}
+
+func (a *Admin) begin() (*txn, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, uploads: []upload{}, afterCommit: []func(){}}, nil
+}
+
+func (tx *txn) commit() error {
+	err := tx.Tx.Commit()
+	if err != nil {
+		tx.removeUploads()
+		return err
+	}
+	for _, fn := range tx.afterCommit {
+		fn()
+	}
+	return nil
+}
+
+func (tx *txn) rollback() error {
+	tx.removeUploads()
+	return tx.Tx.Rollback()
+}
+
+// removeFile deletes a file a row no longer uses once the transaction has been committed, if its field cleans up.
+func (tx *txn) removeFile(field fields.Field, val interface{}) {
+	cleanupField, ok := field.(fields.CleanupField)
+	if !ok || !cleanupField.CleanupFiles() {
+		return
+	}
+	if b, ok := val.([]byte); ok {
+		val = string(b)
+	}
+	name, ok := val.(string)
+	if !ok || len(name) == 0 {
+		return
+	}
+	tx.afterCommit = append(tx.afterCommit, func() {
+		err := cleanupField.DeleteFile(name)
+		if err != nil {
+			fmt.Println(err)
+		}
+	})
+}
+
+func (tx *txn) removeUploads() {
+	for _, u := range tx.uploads {
+		err := u.field.DeleteFile(u.name)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
 type model struct {
 	Name      string
 	Slug      string
 	fields    []fields.Field
 	tableName string
 
+	// The registered struct, and the index of each field's struct field
+	typ        reflect.Type
+	fieldIndex map[string]int
+
 	fieldNames        []string
 	listFields        []fields.Field
 	searchableColumns []string
+	filterFields      []fields.Field
 	sort              string
+	actions           []*action
+	inlines           []*inline
+
+	// Whether the primary key (the first field) is generated by the database, or entered / generated by the admin
+	autoKey bool
+
+	beforeSave   BeforeSaveModel
+	afterSave    AfterSaveModel
+	beforeDelete BeforeDeleteModel
+	afterDelete  AfterDeleteModel
 
 	admin *Admin
+	group *modelGroup
 }
 
 func (m *model) renderForm(w io.Writer, data map[string]interface{}, defaults bool, errors map[string]string) {
+	// Keys that aren't generated by the database are entered when adding rows
+	if defaults && !m.autoKey {
+		m.fields[0].Render(w, data[m.fieldNames[0]], errors[m.fieldNames[0]], true)
+	}
+	m.renderFields(w, "", data, defaults, errors, nil)
+}
+
+// renderFields renders the form fields, except skip. Field names get prefix added, so several forms for the same
+// model can be on one page. Errors are looked up by the prefixed names.
+func (m *model) renderFields(w io.Writer, prefix string, data map[string]interface{}, defaults bool, errors map[string]string, skip fields.Field) {
 	var val interface{}
 	var ok bool
 	activeCol := 0
 	for _, fieldName := range m.fieldNames[1:] {
 		field := m.fieldByName(fieldName)
+		if field == skip {
+			continue
+		}
 		val, ok = data[fieldName]
 		if !ok && defaults {
 			val = field.Attrs().DefaultValue
@@ -283,14 +488,70 @@ func (m *model) renderForm(w io.Writer, data map[string]interface{}, defaults bo
 		// Error text displayed below field, if any
 		var err string
 		if errors != nil {
-			err = errors[fieldName]
+			err = errors[prefix+fieldName]
 		}
 
+		field = prefixedField(field, prefix)
 		field.Render(w, val, err, activeCol%12 == 0)
 		activeCol += field.Attrs().Width
 	}
 }
 
+// prefixedField returns a copy of field with prefix added to its name.
+func prefixedField(field fields.Field, prefix string) fields.Field {
+	if len(prefix) == 0 {
+		return field
+	}
+
+	orig := reflect.ValueOf(field).Elem()
+	copied := reflect.New(orig.Type())
+	copied.Elem().Set(orig)
+
+	attrs := *field.Attrs()
+	attrs.Name = prefix + attrs.Name
+	setBaseField(copied.Elem(), &attrs)
+	return copied.Interface().(fields.Field)
+}
+
+// setBaseField sets the BaseField of a field, which is expected to be the first field of its struct, or of the field
+// it embeds first (like the FileField of an ImageField). Embedded fields are copied, so other fields sharing them
+// aren't changed.
+func setBaseField(v reflect.Value, base *fields.BaseField) {
+	first := v.Field(0)
+	if first.Type() == reflect.TypeOf(base) {
+		first.Set(reflect.ValueOf(base))
+		return
+	}
+
+	embedded := reflect.New(first.Type().Elem())
+	if !first.IsNil() {
+		embedded.Elem().Set(first.Elem())
+	}
+	setBaseField(embedded.Elem(), base)
+	first.Set(embedded)
+}
+
+// pkColumn returns the name of the primary key column.
+func (m *model) pkColumn() string {
+	return m.fields[0].Attrs().ColumnName
+}
+
+// relatedPK returns the primary key column of the table a relational field points to.
+func (a *Admin) relatedPK(field fields.RelationalField) string {
+	if related, ok := a.models[field.GetModelSlug()]; ok {
+		return related.pkColumn()
+	}
+	return "id"
+}
+
+// relatedAutoKey returns true if the model a relational field points to has integer keys generated by the database.
+func (a *Admin) relatedAutoKey(field fields.RelationalField) bool {
+	if related, ok := a.models[field.GetModelSlug()]; ok {
+		return related.autoKey
+	}
+	return true
+}
+
 func (m *model) fieldByName(name string) fields.Field {
 	for _, field := range m.fields {
 		if field.Attrs().Name == name {
@@ -300,7 +561,9 @@ func (m *model) fieldByName(name string) fields.Field {
 	return nil
 }
 
-func (m *model) get(id int) (map[string]interface{}, error) {
+// get loads the row with the given id. Inside a transaction, q must be the transaction, so rows written as part of it
+// are seen, and no second connection is needed.
+func (m *model) get(q db.Queryer, id string) (map[string]interface{}, error) {
 	cols := make([]string, 0, len(m.fieldNames))
 	m2mFields := map[string]struct{}{}
 
@@ -313,14 +576,11 @@ func (m *model) get(id int) (map[string]interface{}, error) {
 		}
 
 		// Normal columns will be loaded directly in the main query
-		if m.admin.NameTransform != nil {
-			fieldName = m.admin.NameTransform(fieldName)
-		}
-		cols = append(cols, fieldName)
+		cols = append(cols, m.admin.quote(m.fieldByName(fieldName).Attrs().ColumnName))
 	}
 
-	q := m.admin.dialect.Queryf("SELECT %v FROM %v WHERE id = ?", strings.Join(cols, ", "), m.tableName)
-	row := m.admin.db.QueryRow(q, id)
+	query := m.admin.dialect.Queryf("SELECT %v FROM %v WHERE %v = ?", strings.Join(cols, ", "), m.admin.quote(m.tableName), m.admin.quote(m.pkColumn()))
+	row := q.QueryRow(query, id)
 
 	result, err := db.ScanRow(len(cols), row)
 	if err != nil {
@@ -337,26 +597,33 @@ func (m *model) get(id int) (map[string]interface{}, error) {
 			if !ok {
 				continue
 			}
-			table_name := fmt.Sprintf("%v_%v", m.tableName, field.ColumnName)
-			if field.GetRelationTable() != "" {
-				table_name = field.GetRelationTable()
-			}
-
-			relTable := field.GetRelatedTable()
+			table_name := m.m2mTable(field)
+			fromColumn, toColumn := m.m2mColumns(field)
 
-			q := m.admin.dialect.Queryf("SELECT %v_id FROM %v WHERE %v_id = ?", relTable, table_name, m.tableName)
+			query := m.admin.dialect.Queryf("SELECT %v FROM %v WHERE %v = ?", m.admin.quote(toColumn), m.admin.quote(table_name), m.admin.quote(fromColumn))
+			if orderColumn := field.Attrs().RelationOrder; orderColumn != "" {
+				query += " ORDER BY " + m.admin.quote(orderColumn)
+			}
 
-			rows, err := m.admin.db.Query(q, id)
+			rows, err := q.Query(query, id)
 			if err != nil {
 				return nil, err
 			}
 
-			ids := []int{}
+			ids := []string{}
 			for rows.Next() {
-				var relId int
-				rows.Scan(&relId)
+				var relId string
+				err = rows.Scan(&relId)
+				if err != nil {
+					rows.Close()
+					return nil, err
+				}
 				ids = append(ids, relId)
 			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return nil, err
+			}
 
 			resultMap[fieldName] = ids
 			continue
@@ -370,156 +637,242 @@ func (m *model) get(id int) (map[string]interface{}, error) {
 	return resultMap, nil
 }
 
-func (m *model) page(page int, search, sortBy string, sortDesc bool) ([][]interface{}, int, error) {
+func (m *model) page(page int, search string, filters url.Values, sortBy string, sortDesc bool) ([][]interface{}, int, error) {
 	page--
 
-	// Ugly search. Will fix later.
-	doSearch := false
-	whereStr := ""
-	searchBlock := ""
-	aliasIndex := 1
-	if len(search) > 0 {
-		searchBlock = fmt.Sprintf(" WHERE %v.id IN (SELECT id FROM (SELECT %v.id, ", m.tableName, m.tableName)
+	rowQuery, countQuery, args := m.listQuery(search, filters, sortBy, sortDesc)
+
+	numRows := 0
+	err := m.admin.db.QueryRow(m.admin.dialect.Queryf("%v", countQuery), args...).Scan(&numRows)
+	if err != nil {
+		return nil, numRows, err
+	}
+
+	results := [][]interface{}{}
+	rowQuery = m.admin.dialect.Queryf("%v %v", rowQuery, m.admin.dialect.Paginate(25, page*25))
+	err = m.eachRow(rowQuery, args, func(result []interface{}) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, numRows, err
 	}
 
+	return results, numRows, nil
+}
+
+// listQuery returns the queries for the list view's rows (without pagination) and row count, and their arguments.
+// Placeholders are not yet converted by the dialect, so more clauses can be added.
+func (m *model) listQuery(search string, filters url.Values, sortBy string, sortDesc bool) (string, string, []interface{}) {
 	cols := []string{}
-	tables := []string{m.tableName}
-	for _, field := range m.fields {
-		if field.Attrs().List {
-			var colSearch string
-			colName := fmt.Sprintf("%v.%v", m.tableName, field.Attrs().ColumnName)
-			if relField, ok := field.(fields.RelationalField); ok && len(relField.GetListColumn()) > 0 {
-				var fkColName, relTable string
-				if _, ok := field.(*fields.ManyToManyField); ok {
-					relTable = fmt.Sprintf("%v_%v", m.tableName, field.Attrs().ColumnName)
-					if relField.GetRelationTable() != "" {
-						relTable = relField.GetRelationTable()
-					}
-					fkColName = fmt.Sprintf("%v.%v", relField.GetRelatedTable(), relField.GetListColumn())
-					colName = fmt.Sprintf(`(SELECT GROUP_CONCAT(%v) FROM %v JOIN %v ON %v.%v_id = %v.id WHERE %v.%v_id = %v.id) AS "%v.%v"`,
-						fkColName, relTable, relField.GetRelatedTable(), relTable,
-						relField.GetRelatedTable(), relField.GetRelatedTable(),
-						relTable, m.tableName, m.tableName, m.tableName, field.Attrs().ColumnName)
-
-				} else if _, ok := field.(*fields.ForeignKeyField); ok {
-					relTable = relField.GetRelatedTable()
-					fkColName = fmt.Sprintf("%v.%v", relTable, relField.GetListColumn())
-					colName = fmt.Sprintf(`(SELECT GROUP_CONCAT(%v) FROM %v WHERE %v.id = %v.%v) AS "%v.%v"`,
-						fkColName, relTable, relTable, m.tableName, field.Attrs().ColumnName, m.tableName, field.Attrs().ColumnName)
-				}
-				colSearch = fmt.Sprintf(`%v AND %v LIKE "%%%v%%") AS alias%v, `, colName[:strings.LastIndex(colName, ")")], fkColName, search, aliasIndex)
-			} else {
-				// Non relational field:
-				colName = fmt.Sprintf(`%v AS "%v"`, colName, colName)
-				colSearch = fmt.Sprintf(`(SELECT %v.%v FROM %v AS _alias WHERE _alias.id = %v.id AND %v.%v LIKE "%%%v%%") AS alias%v, `,
-					m.tableName, field.Attrs().ColumnName, m.tableName, m.tableName,
-					m.tableName, field.Attrs().ColumnName, search, aliasIndex)
-			}
-			if len(search) > 0 && field.Attrs().Searchable {
-				doSearch = true
+	for _, field := range m.listFields {
+		cols = append(cols, m.listColumn(field))
+	}
+	sqlColumns := strings.Join(cols, ", ")
 
-				searchBlock += colSearch
+	// The same WHERE clause and arguments are used for both the rows and the count, so they always match
+	where, args := m.where(search, filters)
 
-				aliasIndex++
-			}
+	if sortField := m.fieldByName(sortBy); sortField != nil {
+		sortCol := sortField.Attrs().ColumnName
 
-			if len(tables) == 0 {
-				tables = append(tables, m.tableName)
-			}
-			cols = append(cols, colName)
+		direction := "ASC"
+		if sortDesc {
+			direction = "DESC"
 		}
+
+		sortBy = fmt.Sprintf(" ORDER BY %v %v", m.admin.quote(m.tableName+"."+sortCol), direction)
 	}
 
-	if doSearch {
-		// Chop last comma and space
-		searchBlock = searchBlock[:len(searchBlock)-2]
-		searchBlock += fmt.Sprintf(" FROM %v WHERE (", m.tableName)
-		for i := 1; i < aliasIndex; i++ {
-			searchBlock += fmt.Sprintf(`alias%v != ""`, i)
-			if i < aliasIndex-1 {
-				searchBlock += fmt.Sprintf(" OR ")
-			}
+	rowQuery := fmt.Sprintf("SELECT %v FROM %v%v%v", sqlColumns, m.admin.quote(m.tableName), where, sortBy)
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %v%v", m.admin.quote(m.tableName), where)
+	return rowQuery, countQuery, args
+}
+
+// eachRow runs a list view query and calls fn with every row, without keeping them all in memory.
+func (m *model) eachRow(query string, args []interface{}, fn func([]interface{}) error) error {
+	rows, err := m.admin.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result, err := db.ScanRow(len(m.listFields), rows)
+		if err != nil {
+			return err
+		}
+		err = fn(result)
+		if err != nil {
+			return err
 		}
-		searchBlock += fmt.Sprintf(")))")
-	} else {
-		searchBlock = ""
 	}
-	sqlColumns := strings.Join(cols, ", ")
-	sqlTables := strings.Join(tables, ", ")
 
-	if len(sortBy) > 0 {
-		sortCol := sortBy
-		if m.admin.NameTransform != nil {
-			sortCol = m.admin.NameTransform(sortBy)
+	return rows.Err()
+}
+
+// listColumn returns the SQL expression for a column in the list view, aliased as "table.column". Relational fields
+// with a list column show the related rows' values instead of ids.
+func (m *model) listColumn(field fields.Field) string {
+	q := m.admin.quote
+	colName := q(m.tableName, field.Attrs().ColumnName)
+	alias := q(m.tableName + "." + field.Attrs().ColumnName)
+
+	relField, ok := field.(fields.RelationalField)
+	if !ok || len(relField.GetListColumn()) == 0 {
+		return fmt.Sprintf("%v AS %v", colName, alias)
+	}
+
+	relTable := relField.GetRelatedTable()
+	listCol := q(relTable, relField.GetListColumn())
+	if m2mField, ok := field.(*fields.ManyToManyField); ok {
+		m2mTable := m.m2mTable(field)
+		fromColumn, toColumn := m.m2mColumns(m2mField)
+		return fmt.Sprintf("(SELECT %v FROM %v JOIN %v ON %v = %v WHERE %v = %v) AS %v",
+			m.admin.dialect.StringAgg(listCol, ","), q(m2mTable), q(relTable), q(m2mTable, toColumn), q(relTable, m.admin.relatedPK(relField)),
+			q(m2mTable, fromColumn), q(m.tableName, m.pkColumn()), alias)
+	}
+	return fmt.Sprintf("(SELECT %v FROM %v WHERE %v = %v) AS %v", listCol, q(relTable), q(relTable, m.admin.relatedPK(relField)), colName, alias)
+}
+
+// where combines search and filters into a WHERE clause.
+func (m *model) where(search string, filters url.Values) (string, []interface{}) {
+	conds, args := m.filterConds(filters)
+	if cond, searchArgs := m.searchCond(search); len(cond) > 0 {
+		conds = append(conds, cond)
+		args = append(args, searchArgs...)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// likeEscaper escapes the wildcards in LIKE patterns, with ! as the escape character.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// searchCond builds a condition from a search string. Every word must match at least one of the searchable fields.
+// Relational fields with a list column are searched by the related rows' values. Words are passed as arguments, never
+// spliced into the query.
+func (m *model) searchCond(search string) (string, []interface{}) {
+	terms := strings.Fields(search)
+	if len(terms) == 0 {
+		return "", nil
+	}
+
+	// One condition per searchable field, each with a single placeholder
+	q := m.admin.quote
+	like := func(col string) string {
+		return fmt.Sprintf("%v LIKE ? ESCAPE '!'", m.admin.dialect.Text(col))
+	}
+	conds := []string{}
+	for _, field := range m.fields {
+		if !field.Attrs().Searchable {
+			continue
 		}
 
-		direction := "ASC"
-		if sortDesc {
-			direction = "DESC"
+		colName := q(m.tableName, field.Attrs().ColumnName)
+		relField, ok := field.(fields.RelationalField)
+		if !ok || len(relField.GetListColumn()) == 0 {
+			conds = append(conds, like(colName))
+			continue
 		}
 
-		sortBy = fmt.Sprintf(` ORDER BY "%v.%v" %v`, m.tableName, sortCol, direction)
+		relTable := relField.GetRelatedTable()
+		listCol := q(relTable, relField.GetListColumn())
+		if m2mField, ok := field.(*fields.ManyToManyField); ok {
+			m2mTable := m.m2mTable(field)
+			fromColumn, toColumn := m.m2mColumns(m2mField)
+			conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM %v JOIN %v ON %v = %v WHERE %v = %v AND %v)",
+				q(m2mTable), q(relTable), q(m2mTable, toColumn), q(relTable, m.admin.relatedPK(relField)), q(m2mTable, fromColumn),
+				q(m.tableName, m.pkColumn()), like(listCol)))
+		} else {
+			conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM %v WHERE %v = %v AND %v)",
+				q(relTable), q(relTable, m.admin.relatedPK(relField)), colName, like(listCol)))
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
 	}
 
-	fromWhere := fmt.Sprintf("FROM %v %v%v", sqlTables, whereStr, searchBlock)
+	termConds := make([]string, len(terms))
+	args := make([]interface{}, 0, len(terms)*len(conds))
+	for i, term := range terms {
+		termConds[i] = "(" + strings.Join(conds, " OR ") + ")"
 
-	rowQuery := m.admin.dialect.Queryf("SELECT %v %v%v LIMIT %v,%v", sqlColumns, fromWhere, sortBy, page*25, 25)
-	// fmt.Printf("rowQuery: SELECT %v %v%v LIMIT %v,%v\n", sqlColumns, fromWhere, sortBy, page*25, 25)
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		for range conds {
+			args = append(args, pattern)
+		}
+	}
 
-	var rows *sql.Rows
-	var countRow *sql.Row
-	var countQuery string
-	var err error
-	if doSearch {
-		countQuery = m.admin.dialect.Queryf("SELECT COUNT(*) FROM (SELECT %v %v)", sqlColumns, fromWhere)
-	} else {
-		countQuery = m.admin.dialect.Queryf("SELECT COUNT(*) FROM %v", m.tableName)
+	return strings.Join(termConds, " AND "), args
+}
+
+// m2mTable returns the name of the join table used by a ManyToManyField.
+func (m *model) m2mTable(field fields.Field) string {
+	if relTable := field.Attrs().RelationTable; relTable != "" {
+		return relTable
 	}
+	return fmt.Sprintf("%v_%v", m.tableName, field.Attrs().ColumnName)
+}
 
-	rows, err = m.admin.db.Query(rowQuery)
-	countRow = m.admin.db.QueryRow(countQuery)
+// m2mColumns returns the join table columns pointing to this model and to the related model, named <table>_id unless
+// set with rel_from and rel_to.
+func (m *model) m2mColumns(field *fields.ManyToManyField) (string, string) {
+	fromColumn, toColumn := field.Attrs().RelationFrom, field.Attrs().RelationTo
+	if fromColumn == "" {
+		fromColumn = m.tableName + "_id"
+	}
+	if toColumn == "" {
+		toColumn = field.GetRelatedTable() + "_id"
+	}
+	return fromColumn, toColumn
+}
 
-	numRows := 0
-	err = countRow.Scan(&numRows)
+// save validates POSTed data and inserts or updates the row with the given id ("" for new rows), and records the changes
+// in the audit log. The id of the saved row is returned. Rows of the given inlines posted along with it are saved too,
+// other inlines' form values are ignored. Everything, including M2M relations and inlines, is saved in a single
+// transaction. On errors it's rolled back, uploaded files are removed again, and the validated data is returned so the
+// form can be shown again.
+func (m *model) save(username string, id string, req *http.Request, inlines []*inline) (string, map[string]interface{}, map[string]string, error) {
+	tx, err := m.admin.begin()
 	if err != nil {
-		fmt.Println("SCAN", err)
+		return id, nil, nil, err
 	}
 
+	newId, data, dataErrors, err := m.saveTx(tx, username, id, req, inlines)
 	if err != nil {
-		return nil, numRows, err
+		tx.rollback()
+		return id, data, dataErrors, err
 	}
 
-	numCols := len(cols)
-	results := [][]interface{}{}
-
-	for rows.Next() {
-		result, err := db.ScanRow(numCols, rows)
-		if err != nil {
-			return nil, numRows, err
-		}
-		results = append(results, result)
+	err = tx.commit()
+	if err != nil {
+		return id, data, dataErrors, err
 	}
-
-	return results, numRows, nil
+	return newId, data, dataErrors, nil
 }
 
-func (m *model) save(id int, req *http.Request) (map[string]interface{}, map[string]string, error) {
+// saveTx saves as part of an existing transaction, which is rolled back by the caller on errors.
+func (m *model) saveTx(tx *txn, username string, id string, req *http.Request, inlines []*inline) (string, map[string]interface{}, map[string]string, error) {
 	numFields := len(m.fieldNames) - 1 // No need for ID.
 
 	// Get existing data, if any, so we can check what values were changed (existing == nil for new rows)
 	var existing map[string]interface{}
-	if id != 0 {
+	if id != "" {
 		var err error
-		existing, err = m.get(id)
+		existing, err = m.get(tx, id)
 		if err != nil {
-			return nil, nil, err
+			return id, nil, nil, err
 		}
 	}
 
 	// Get data from POST and fill a slice
 	data := map[string]interface{}{}
-	m2mData := map[string][]int{}
-	m2mChanges := false
+	m2mData := map[string][]string{}
+	changes := []*fieldChange{}
 	dataErrors := map[string]string{}
 	hasErrors := false
 	for i := 0; i < numFields; i++ {
@@ -534,20 +887,32 @@ func (m *model) save(id int, req *http.Request) (map[string]interface{}, map[str
 		if err != nil {
 			dataErrors[fieldName] = err.Error()
 			hasErrors = true
+		} else if fileField, ok := field.(fields.FileHandlerField); ok && req.MultipartForm != nil && len(req.MultipartForm.File[fieldName]) > 0 {
+			tx.uploads = append(tx.uploads, upload{fileField, fmt.Sprint(val)})
 		}
 
 		// ManyToManyField
-		if ids, ok := val.([]int); ok {
-			// Has M2M data changed?
-			if existingIds, ok := existingVal.([]int); ok {
-				// FIXME: Maybe not the best way to compare slices?
-				sort.Ints(ids)
-				sort.Ints(existingIds)
-				m2mChanges = fmt.Sprint(ids) != fmt.Sprint(existingIds)
+		if ids, ok := val.([]string); ok {
+			if err := m.admin.checkRelatedIds(field.(*fields.ManyToManyField), ids); err != nil {
+				dataErrors[fieldName] = err.Error()
+				hasErrors = true
+			}
+
+			// Has M2M data changed? The order only matters if it's stored.
+			m2mChanged := false
+			if existingIds, ok := existingVal.([]string); ok {
+				if field.Attrs().RelationOrder == "" {
+					sort.Strings(ids)
+					sort.Strings(existingIds)
+				}
+				m2mChanged = strings.Join(ids, ",") != strings.Join(existingIds, ",")
 			} else if len(ids) > 0 {
-				m2mChanges = true
+				m2mChanged = true
 			}
 
+			if m2mChanged {
+				changes = append(changes, &fieldChange{fieldName, field.Attrs().Label, existingVal, ids})
+			}
 			m2mData[fieldName] = ids
 			continue
 		}
@@ -555,8 +920,26 @@ func (m *model) save(id int, req *http.Request) (map[string]interface{}, map[str
 		data[fieldName] = val
 	}
 
+	// Keys that aren't generated by the database are entered in the form when adding rows, or generated here
+	if id == "" && !m.autoKey {
+		key := strings.TrimSpace(req.Form.Get(m.fieldNames[0]))
+		if len(key) == 0 {
+			key = newUUID()
+		}
+		data[m.fieldNames[0]] = key
+	}
+
+	// Cross-field validation, once each field is valid
+	if !hasErrors && m.beforeSave != nil {
+		var err error
+		hasErrors, err = m.runBeforeSave(id, data, m2mData, dataErrors)
+		if err != nil {
+			return id, data, dataErrors, err
+		}
+	}
+
 	if hasErrors {
-		return data, dataErrors, errors.New("Please correct the errors below.")
+		return id, data, dataErrors, errors.New("Please correct the errors below.")
 	}
 
 	// Create query only with the changed data
@@ -564,24 +947,33 @@ func (m *model) save(id int, req *http.Request) (map[string]interface{}, map[str
 	changedData := []interface{}{}
 	for key, value := range data {
 		// Skip if not changed
-		if existing != nil && value == existing[key] {
-			continue
+		var existingVal interface{}
+		if existing != nil {
+			existingVal = existing[key]
+			if sameValue(value, existingVal) {
+				continue
+			}
 		}
+		changes = append(changes, &fieldChange{key, m.fieldByName(key).Attrs().Label, existingVal, value})
+		tx.removeFile(m.fieldByName(key), existingVal)
 
 		// Convert to DB version of name and append
-		col := key
-		if m.admin.NameTransform != nil {
-			col = m.admin.NameTransform(key)
-		}
-		if id != 0 {
+		col := m.admin.quote(m.fieldByName(key).Attrs().ColumnName)
+		if id != "" {
 			col = fmt.Sprintf("%v = ?", col)
 		}
 		changedCols = append(changedCols, col)
 		changedData = append(changedData, value)
 	}
 
-	if len(changedCols) == 0 && !m2mChanges {
-		return nil, nil, errors.New(fmt.Sprintf("%v was not saved because there were no changes.", m.Name))
+	// New rows need at least one column, like when a model only has ManyToMany fields
+	if id == "" && len(changedCols) == 0 {
+		return id, data, dataErrors, errors.New(fmt.Sprintf("%v can't be added without any values.", m.Name))
+	}
+
+	// Inlines may have changed even if the object itself hasn't
+	if len(changes) == 0 && (id == "" || len(inlines) == 0) {
+		return id, nil, nil, noChangesError(m.Name)
 	}
 
 	if len(changedCols) > 0 {
@@ -589,21 +981,30 @@ func (m *model) save(id int, req *http.Request) (map[string]interface{}, map[str
 		valMarks = valMarks[0 : len(valMarks)-2]
 
 		// Insert / update
-		var q string
-		if id != 0 {
-			q = m.admin.dialect.Queryf("UPDATE %v SET %v WHERE id = %v", m.tableName, strings.Join(changedCols, ", "), id)
+		if id != "" {
+			q := m.admin.dialect.Queryf("UPDATE %v SET %v WHERE %v = ?", m.admin.quote(m.tableName), strings.Join(changedCols, ", "), m.admin.quote(m.pkColumn()))
+			_, err := tx.Exec(q, append(changedData, id)...)
+			if err != nil {
+				fmt.Println(err)
+				return id, data, dataErrors, err
+			}
 		} else {
-			q = m.admin.dialect.Queryf("INSERT INTO %v(%v) VALUES(%v)", m.tableName, strings.Join(changedCols, ", "), valMarks)
-		}
-
-		result, err := m.admin.db.Exec(q, changedData...)
-		if err != nil {
-			fmt.Println(err)
-			return nil, nil, err
-		}
-
-		if newId, _ := result.LastInsertId(); id == 0 {
-			id = int(newId)
+			q := m.admin.dialect.Queryf("INSERT INTO %v (%v) VALUES (%v)", m.admin.quote(m.tableName), strings.Join(changedCols, ", "), valMarks)
+			if m.autoKey {
+				newId, err := m.admin.dialect.InsertId(tx, q, m.pkColumn(), changedData...)
+				if err != nil {
+					fmt.Println(err)
+					return id, data, dataErrors, err
+				}
+				id = strconv.FormatInt(newId, 10)
+			} else {
+				_, err := tx.Exec(q, changedData...)
+				if err != nil {
+					fmt.Println(err)
+					return id, data, dataErrors, err
+				}
+				id = fmt.Sprint(data[m.fieldNames[0]])
+			}
 		}
 	}
 
@@ -611,30 +1012,124 @@ func (m *model) save(id int, req *http.Request) (map[string]interface{}, map[str
 	// Insert / update M2M
 	for fieldName, ids := range m2mData {
 		field, _ := m.fieldByName(fieldName).(*fields.ManyToManyField)
-		err := m.saveM2M(id, field, ids)
+		err := m.saveM2M(tx, id, field, ids)
 		if err != nil {
-			return nil, nil, err
+			return id, data, dataErrors, err
 		}
 	}
 	// }
 
-	return data, dataErrors, nil
-}
+	if len(changes) > 0 {
+		action := logUpdate
+		if existing == nil {
+			action = logCreate
+		}
+		err := m.log(tx, username, id, action, changes)
+		if err != nil {
+			return id, data, dataErrors, err
+		}
+	}
 
-func (m *model) saveM2M(id int, field *fields.ManyToManyField, relatedIds []int) error {
-	m2mTable := fmt.Sprintf("%v_%v", m.tableName, field.ColumnName)
-	if field.GetRelationTable() != "" {
-		m2mTable = field.GetRelationTable()
+	// Inline rows need the object's id, so they are saved last
+	if len(inlines) > 0 {
+		inlinesChanged, inlineErrors, err := m.saveInlines(tx, username, id, req, inlines)
+		if err != nil {
+			return id, data, dataErrors, err
+		}
+		if len(inlineErrors) > 0 {
+			for name, msg := range inlineErrors {
+				dataErrors[name] = msg
+			}
+			return id, data, dataErrors, errors.New("Please correct the errors below.")
+		}
+		if len(changes) == 0 && !inlinesChanged {
+			return id, nil, nil, noChangesError(m.Name)
+		}
 	}
 
-	toColumn := fmt.Sprintf("%v_id", field.GetRelatedTable())
-	fromColumn := fmt.Sprintf("%v_id", m.tableName)
+	if m.afterSave != nil {
+		obj, err := m.newStruct(id, data, m2mData)
+		if err != nil {
+			return id, data, dataErrors, err
+		}
+		tx.afterCommit = append(tx.afterCommit, func() { obj.Interface().(AfterSaveModel).AfterSave() })
+	}
+	return id, data, dataErrors, nil
+}
 
-	tx, err := m.admin.db.Begin()
+// runBeforeSave calls the BeforeSave hook with the row about to be saved, and updates data and m2mData with the fields
+// it changed, validated like posted values. Errors are added to dataErrors, and it reports whether there were any.
+func (m *model) runBeforeSave(id string, data map[string]interface{}, m2mData map[string][]string, dataErrors map[string]string) (bool, error) {
+	obj, err := m.newStruct(id, data, m2mData)
 	if err != nil {
-		return err
+		return false, err
+	}
+	before := map[string]string{}
+	for _, fieldName := range m.fieldNames[1:] {
+		before[fieldName] = m.admin.structFormValue(obj.Elem().Field(m.fieldIndex[fieldName]))
+	}
+
+	hookErrors := obj.Interface().(BeforeSaveModel).BeforeSave()
+
+	for _, fieldName := range m.fieldNames[1:] {
+		after := m.admin.structFormValue(obj.Elem().Field(m.fieldIndex[fieldName]))
+		if after == before[fieldName] {
+			continue
+		}
+		val, err := fields.Validate(m.fieldByName(fieldName), &http.Request{Form: url.Values{fieldName: {after}}}, data[fieldName])
+		if err != nil {
+			dataErrors[fieldName] = err.Error()
+		} else if ids, ok := val.([]string); ok {
+			m2mData[fieldName] = ids
+		} else {
+			data[fieldName] = val
+		}
 	}
-	defer tx.Commit()
+	for fieldName, msg := range hookErrors {
+		dataErrors[fieldName] = msg
+	}
+	return len(dataErrors) > 0, nil
+}
+
+// sameValue compares a validated value with one loaded from the database, which may have a different type. Foreign
+// keys are validated as strings but stored as numbers, and booleans may be stored as 0 / 1.
+func sameValue(val, existing interface{}) bool {
+	if val == nil || existing == nil {
+		return val == existing
+	}
+	if t, ok := val.(time.Time); ok {
+		existingTime, ok := existing.(time.Time)
+		return ok && t.Equal(existingTime)
+	}
+	if b, ok := val.(bool); ok {
+		if i, ok := existing.(int64); ok {
+			return b == (i == 1)
+		}
+	}
+	return fmt.Sprint(val) == fmt.Sprint(existing)
+}
+
+// refusedError is returned by delete when a BeforeDelete hook prevents a row from being deleted.
+type refusedError string
+
+func (e refusedError) Error() string {
+	return string(e)
+}
+
+// noChangesError is returned by save when the submitted data is the same as what's stored.
+type noChangesError string
+
+func (e noChangesError) Error() string {
+	return fmt.Sprintf("%v was not saved because there were no changes.", string(e))
+}
+
+// saveM2M adds and removes rows in a ManyToManyField's join table, leaving rows for ids that are kept (and any extra
+// columns they have) alone. If the field has a rel_order column, it's set to each id's position.
+func (m *model) saveM2M(tx *txn, id string, field *fields.ManyToManyField, relatedIds []string) error {
+	q := m.admin.quote
+	m2mTable := q(m.m2mTable(field))
+	fromColumn, toColumn := m.m2mColumns(field)
+	fromColumn, toColumn = q(fromColumn), q(toColumn)
 
 	existingRelQuery := m.admin.dialect.Queryf("SELECT %v FROM %v WHERE %v = ?", toColumn, m2mTable, fromColumn)
 	rows, err := tx.Query(existingRelQuery, id)
@@ -642,58 +1137,136 @@ func (m *model) saveM2M(id int, field *fields.ManyToManyField, relatedIds []int)
 		return err
 	}
 
-	removeRels := map[int]bool{}
+	removeRels := map[string]bool{}
 	for rows.Next() {
-		var eId int
-		rows.Scan(&eId)
+		var eId string
+		err = rows.Scan(&eId)
+		if err != nil {
+			rows.Close()
+			return err
+		}
 		removeRels[eId] = true
 	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
 
 	// Add new, remove from removeRels as we go. Those still left in removeRels will be deleted.
-	for _, nId := range relatedIds {
+	orderColumn := field.Attrs().RelationOrder
+	for i, nId := range relatedIds {
 		if _, ok := removeRels[nId]; ok {
 			// Already exists,
 			delete(removeRels, nId)
+			if orderColumn != "" {
+				orderRelQuery := m.admin.dialect.Queryf("UPDATE %v SET %v = ? WHERE %v = ? AND %v = ?", m2mTable, q(orderColumn), fromColumn, toColumn)
+				_, err = tx.Exec(orderRelQuery, i, id, nId)
+			}
+		} else if orderColumn != "" {
+			addRelQuery := m.admin.dialect.Queryf("INSERT INTO %v (%v, %v, %v) VALUES (?, ?, ?)", m2mTable, fromColumn, toColumn, q(orderColumn))
+			_, err = tx.Exec(addRelQuery, id, nId, i)
 		} else {
 			// Relation doesn't exist yet, so add it
 			addRelQuery := m.admin.dialect.Queryf("INSERT INTO %v (%v, %v) VALUES (?, ?)", m2mTable, fromColumn, toColumn)
-			tx.Exec(addRelQuery, id, nId)
+			_, err = tx.Exec(addRelQuery, id, nId)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
 	// Delete remaining Ids in removeRels as they're no longer related
 	for eId, _ := range removeRels {
 		removeRelQuery := m.admin.dialect.Queryf("DELETE FROM %v WHERE %v = ? AND %v = ?", m2mTable, fromColumn, toColumn)
-		tx.Exec(removeRelQuery, id, eId)
+		_, err = tx.Exec(removeRelQuery, id, eId)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (m *model) delete(id int) error {
-	_, err := m.get(id)
+// checkRelatedIds makes sure the ids of a ManyToManyField are integers if the related model's keys are.
+func (a *Admin) checkRelatedIds(field *fields.ManyToManyField, ids []string) error {
+	if !a.relatedAutoKey(field) {
+		return nil
+	}
+	for _, id := range ids {
+		if _, err := strconv.Atoi(id); err != nil {
+			return errors.New(fmt.Sprintf("%v is not a valid id.", id))
+		}
+	}
+	return nil
+}
+
+// delete removes a row and its M2M relations in a single transaction.
+func (m *model) delete(username string, id string) error {
+	tx, err := m.admin.begin()
 	if err != nil {
 		return err
 	}
 
-	q := fmt.Sprintf("DELETE FROM %v WHERE id=?", m.tableName)
-	_, err = m.admin.db.Exec(q, id)
+	err = m.deleteTx(tx, username, id)
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	return tx.commit()
+}
+
+// deleteTx deletes a row as part of an existing transaction, which is rolled back by the caller on errors.
+func (m *model) deleteTx(tx *txn, username string, id string) error {
+	existing, err := m.get(tx, id)
 	if err != nil {
 		return err
 	}
 
-	// Delete M2M relations
+	var obj reflect.Value
+	if m.beforeDelete != nil || m.afterDelete != nil {
+		obj, err = m.newStruct(id, existing, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if m.beforeDelete != nil {
+		err = obj.Interface().(BeforeDeleteModel).BeforeDelete()
+		if err != nil {
+			return refusedError(err.Error())
+		}
+	}
+
+	// Delete M2M relations first, as they may reference the row
 	for _, fieldName := range m.fieldNames {
 		if field, ok := m.fieldByName(fieldName).(*fields.ManyToManyField); ok {
-			m2mTable := fmt.Sprintf("%v_%v", m.tableName, field.ColumnName)
-			if field.GetRelationTable() != "" {
-				m2mTable = field.GetRelationTable()
+			fromColumn, _ := m.m2mColumns(field)
+			q := m.admin.dialect.Queryf("DELETE FROM %v WHERE %v = ?", m.admin.quote(m.m2mTable(field)), m.admin.quote(fromColumn))
+			_, err = tx.Exec(q, id)
+			if err != nil {
+				return err
 			}
-			fromColumn := fmt.Sprintf("%v_id", m.tableName)
-			q := m.admin.dialect.Queryf("DELETE FROM %v WHERE %v = ?", m2mTable, fromColumn)
-			m.admin.db.Exec(q, id)
 		}
 	}
 
+	q := m.admin.dialect.Queryf("DELETE FROM %v WHERE %v = ?", m.admin.quote(m.tableName), m.admin.quote(m.pkColumn()))
+	_, err = tx.Exec(q, id)
+	if err != nil {
+		return err
+	}
+
+	// Log the values the object had before it was deleted
+	changes := []*fieldChange{}
+	for _, fieldName := range m.fieldNames[1:] {
+		changes = append(changes, &fieldChange{fieldName, m.fieldByName(fieldName).Attrs().Label, existing[fieldName], nil})
+		tx.removeFile(m.fieldByName(fieldName), existing[fieldName])
+	}
+	err = m.log(tx, username, id, logDelete, changes)
+	if err != nil {
+		return err
+	}
+
+	if m.afterDelete != nil {
+		tx.afterCommit = append(tx.afterCommit, func() { obj.Interface().(AfterDeleteModel).AfterDelete() })
+	}
 	return nil
 }
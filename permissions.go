@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Permission is a set of actions a user is allowed to perform on a model. Combine them with |, like
+// PermView|PermChange.
+type Permission uint8
+
+const (
+	PermView Permission = 1 << iota
+	PermAdd
+	PermChange
+	PermDelete
+
+	PermAll = PermView | PermAdd | PermChange | PermDelete
+)
+
+func (p Permission) CanView() bool {
+	return p&PermView != 0
+}
+
+func (p Permission) CanAdd() bool {
+	return p&PermAdd != 0
+}
+
+func (p Permission) CanChange() bool {
+	return p&PermChange != 0
+}
+
+func (p Permission) CanDelete() bool {
+	return p&PermDelete != 0
+}
+
+// Authorizer decides what a logged in user may do with a model. Group and model are slugs, as seen in the admin's
+// URLs. Set Admin.Permissions to use one. Grants is a simple, built-in implementation.
+type Authorizer interface {
+	Permissions(username, group, model string) (Permission, error)
+}
+
+// AuthorizerFunc allows an ordinary function to be used as an Authorizer, for example to look up permissions in your
+// own user table.
+type AuthorizerFunc func(username, group, model string) (Permission, error)
+
+func (f AuthorizerFunc) Permissions(username, group, model string) (Permission, error) {
+	return f(username, group, model)
+}
+
+type grant struct {
+	group string
+	model string
+	perm  Permission
+}
+
+// Grants is an Authorizer where permissions are granted to roles, either for single models or whole groups, and
+// users are assigned one or more roles.
+type Grants struct {
+	roles map[string][]grant
+	users map[string][]string
+}
+
+func NewGrants() *Grants {
+	return &Grants{
+		roles: map[string][]grant{},
+		users: map[string][]string{},
+	}
+}
+
+// GrantModel gives role perm on the model with the given slug.
+func (g *Grants) GrantModel(role, model string, perm Permission) {
+	g.roles[role] = append(g.roles[role], grant{model: model, perm: perm})
+}
+
+// GrantGroup gives role perm on all models in the group with the given slug.
+func (g *Grants) GrantGroup(role, group string, perm Permission) {
+	g.roles[role] = append(g.roles[role], grant{group: group, perm: perm})
+}
+
+// Assign adds one or more roles to a user.
+func (g *Grants) Assign(username string, roles ...string) {
+	g.users[username] = append(g.users[username], roles...)
+}
+
+func (g *Grants) Permissions(username, group, model string) (Permission, error) {
+	var perm Permission
+	for _, role := range g.users[username] {
+		for _, gr := range g.roles[role] {
+			if (len(gr.model) > 0 && gr.model == model) || (len(gr.group) > 0 && gr.group == group) {
+				perm |= gr.perm
+			}
+		}
+	}
+	return perm, nil
+}
+
+// permissions returns what the logged in user may do with a model. Without an Authorizer, everything is allowed.
+func (a *Admin) permissions(req *http.Request, m *model) Permission {
+	if a.Permissions == nil {
+		return PermAll
+	}
+
+	sess := a.getUserSession(req)
+	if sess == nil {
+		return 0
+	}
+
+	perm, err := a.Permissions.Permissions(sess.Username, m.group.slug, m.Slug)
+	if err != nil {
+		fmt.Println(err)
+		return 0
+	}
+	return perm
+}
+
+func (a *Admin) forbidden(rw http.ResponseWriter) {
+	http.Error(rw, "You don't have permission to do that.", 403)
+}
@@ -0,0 +1,281 @@
+package admin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oal/admin/db"
+	"github.com/oal/admin/fields"
+)
+
+// SchemaError lists the differences between the registered models and the database found by Check.
+type SchemaError []string
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("The database doesn't match the registered models:\n  %v", strings.Join(e, "\n  "))
+}
+
+// Check compares the registered models with the database, and returns a SchemaError listing missing tables and
+// columns, columns with types that don't fit their field, and missing related and M2M join tables. It's also run by
+// Handler, which only prints the result.
+func (a *Admin) Check() error {
+	mismatches := SchemaError{}
+	tables := map[string]map[string]string{}
+	columns := func(table string) (map[string]string, error) {
+		if cols, ok := tables[table]; ok {
+			return cols, nil
+		}
+		cols, err := a.dialect.Columns(a.db, table)
+		if err != nil {
+			return nil, err
+		}
+		tables[table] = cols
+		return cols, nil
+	}
+
+	for _, group := range a.modelGroups {
+		for _, m := range group.Models {
+			cols, err := columns(m.tableName)
+			if err != nil {
+				return err
+			}
+			if len(cols) == 0 {
+				mismatches = append(mismatches, fmt.Sprintf("%v: table %v doesn't exist.", m.Name, m.tableName))
+				continue
+			}
+
+			for _, field := range m.fields {
+				name := fmt.Sprintf("%v.%v", m.Name, field.Attrs().Name)
+
+				if relField, ok := field.(fields.RelationalField); ok {
+					if len(relField.GetModelSlug()) == 0 {
+						mismatches = append(mismatches, fmt.Sprintf("%v: the related model isn't registered.", name))
+					}
+
+					relCols, err := columns(relField.GetRelatedTable())
+					if err != nil {
+						return err
+					}
+					if len(relCols) == 0 {
+						mismatches = append(mismatches, fmt.Sprintf("%v: related table %v doesn't exist.", name, relField.GetRelatedTable()))
+					}
+				}
+
+				// ManyToManyFields are stored in a join table instead of a column
+				if m2mField, ok := field.(*fields.ManyToManyField); ok {
+					m2mTable := m.m2mTable(m2mField)
+					m2mCols, err := columns(m2mTable)
+					if err != nil {
+						return err
+					}
+					if len(m2mCols) == 0 {
+						mismatches = append(mismatches, fmt.Sprintf("%v: join table %v doesn't exist.", name, m2mTable))
+						continue
+					}
+					fromColumn, toColumn := m.m2mColumns(m2mField)
+					m2mColumns := []string{fromColumn, toColumn}
+					if orderColumn := m2mField.Attrs().RelationOrder; orderColumn != "" {
+						m2mColumns = append(m2mColumns, orderColumn)
+					}
+					for _, col := range m2mColumns {
+						if _, ok := a.findColumn(m2mCols, col); !ok {
+							mismatches = append(mismatches, fmt.Sprintf("%v: column %v doesn't exist in join table %v.", name, col, m2mTable))
+						}
+					}
+					continue
+				}
+
+				column := field.Attrs().ColumnName
+				colType, ok := a.findColumn(cols, column)
+				if !ok {
+					mismatches = append(mismatches, fmt.Sprintf("%v: column %v doesn't exist in table %v.", name, column, m.tableName))
+					continue
+				}
+
+				// Foreign keys have the type of the related model's key
+				typeField := field
+				if fkField, ok := field.(*fields.ForeignKeyField); ok {
+					if related, ok := a.models[fkField.GetModelSlug()]; ok {
+						typeField = related.fields[0]
+					}
+				}
+				if kind, types := columnTypes(typeField); len(colType) > 0 && !hasAnySubstring(strings.ToLower(colType), types) {
+					mismatches = append(mismatches, fmt.Sprintf("%v: column %v is %v, not %v.", name, column, colType, kind))
+				}
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return mismatches
+	}
+	return nil
+}
+
+// findColumn returns the type of a column. Only PostgreSQL treats quoted names as case sensitive.
+func (a *Admin) findColumn(columns map[string]string, name string) (string, bool) {
+	if colType, ok := columns[name]; ok {
+		return colType, true
+	}
+	if _, ok := a.dialect.(db.PostgresDialect); ok {
+		return "", false
+	}
+	for col, colType := range columns {
+		if strings.EqualFold(col, name) {
+			return colType, true
+		}
+	}
+	return "", false
+}
+
+// columnTypes describes the column types a field can be stored in, as parts of type names. Custom fields can be stored
+// in anything.
+func columnTypes(field fields.Field) (string, []string) {
+	switch field.(type) {
+	case *fields.IntField, *fields.ForeignKeyField:
+		return "an integer", []string{"int", "serial", "numeric", "decimal"}
+	case *fields.FloatField:
+		return "a number", []string{"real", "float", "double", "numeric", "decimal"}
+	case *fields.BooleanField:
+		return "a boolean", []string{"bool", "int", "bit"}
+	case *fields.TimeField:
+		return "a date or time", []string{"date", "time"}
+	case *fields.TextField:
+		return "text", []string{"char", "text", "clob"}
+	}
+	return "", nil
+}
+
+func hasAnySubstring(s string, substrings []string) bool {
+	if substrings == nil {
+		return true
+	}
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncSchema creates the tables of the registered models (and join tables for their ManyToManyFields) if they don't
+// exist, and adds columns for fields added since. Columns are never removed or changed. Added columns allow NULL, as
+// there may already be rows in the table. It returns the statements it ran, so they can be logged or reviewed.
+func (a *Admin) SyncSchema() ([]string, error) {
+	statements := []string{}
+	for _, group := range a.modelGroups {
+		for _, m := range group.Models {
+			columns := []string{}
+			definitions := []string{}
+			for i, field := range m.fields {
+				if m2mField, ok := field.(*fields.ManyToManyField); ok {
+					fromColumn, toColumn := m.m2mColumns(m2mField)
+					m2mColumns := []string{fromColumn, toColumn}
+					m2mDefinitions := []string{a.keyColumnType(m) + " NOT NULL", a.relatedKeyColumnType(m2mField) + " NOT NULL"}
+					if orderColumn := m2mField.Attrs().RelationOrder; orderColumn != "" {
+						m2mColumns = append(m2mColumns, orderColumn)
+						m2mDefinitions = append(m2mDefinitions, a.dialect.ColumnType(db.Integer, 0)+" NOT NULL")
+					}
+					run, err := a.syncTable(m.m2mTable(m2mField), m2mColumns, m2mDefinitions)
+					statements = append(statements, run...)
+					if err != nil {
+						return statements, err
+					}
+					continue
+				}
+
+				definition := a.dialect.ColumnType(columnType(field))
+				if fkField, ok := field.(*fields.ForeignKeyField); ok {
+					definition = a.relatedKeyColumnType(fkField)
+				}
+				if i == 0 && m.autoKey {
+					definition = a.dialect.ColumnType(db.PrimaryKey, 0)
+				} else if i == 0 {
+					definition = a.keyColumnType(m) + " NOT NULL PRIMARY KEY"
+				} else if !field.Attrs().Null {
+					definition += " NOT NULL"
+				}
+				columns = append(columns, field.Attrs().ColumnName)
+				definitions = append(definitions, definition)
+			}
+
+			run, err := a.syncTable(m.tableName, columns, definitions)
+			statements = append(statements, run...)
+			if err != nil {
+				return statements, err
+			}
+		}
+	}
+	return statements, nil
+}
+
+// syncTable creates a table, or adds the columns it's missing, and returns the statements it ran.
+func (a *Admin) syncTable(table string, columns, definitions []string) ([]string, error) {
+	existing, err := a.dialect.Columns(a.db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []string{}
+	if len(existing) == 0 {
+		cols := make([]string, len(columns))
+		for i, col := range columns {
+			cols[i] = fmt.Sprintf("%v %v", a.quote(col), definitions[i])
+		}
+		statements = append(statements, fmt.Sprintf("CREATE TABLE %v (%v)", a.quote(table), strings.Join(cols, ", ")))
+	} else {
+		for i, col := range columns {
+			if _, ok := a.findColumn(existing, col); ok {
+				continue
+			}
+			definition := strings.TrimSuffix(definitions[i], " NOT NULL")
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", a.quote(table), a.quote(col), definition))
+		}
+	}
+
+	for i, q := range statements {
+		_, err = a.db.Exec(q)
+		if err != nil {
+			return statements[:i], err
+		}
+	}
+	return statements, nil
+}
+
+// keyColumnType returns the type of a model's primary key column, as used by other tables. Text keys are limited to
+// 255 characters unless they have a maxlength, as some databases can't index longer text.
+func (a *Admin) keyColumnType(m *model) string {
+	if m.autoKey {
+		return a.dialect.ColumnType(db.Integer, 0)
+	}
+	t, size := columnType(m.fields[0])
+	if t == db.Text && size == 0 {
+		size = 255
+	}
+	return a.dialect.ColumnType(t, size)
+}
+
+// relatedKeyColumnType returns the type of the columns pointing to the model a field is related to.
+func (a *Admin) relatedKeyColumnType(field fields.RelationalField) string {
+	if related, ok := a.models[field.GetModelSlug()]; ok {
+		return a.keyColumnType(related)
+	}
+	return a.dialect.ColumnType(db.Integer, 0)
+}
+
+// columnType returns the type SyncSchema uses for a field's column. Custom fields are stored as text.
+func columnType(field fields.Field) (db.ColumnType, int) {
+	switch f := field.(type) {
+	case *fields.IntField, *fields.ForeignKeyField:
+		return db.Integer, 0
+	case *fields.FloatField:
+		return db.Float, 0
+	case *fields.BooleanField:
+		return db.Boolean, 0
+	case *fields.TimeField:
+		return db.Time, 0
+	case *fields.TextField:
+		return db.Text, f.MaxLength
+	}
+	return db.Text, 0
+}
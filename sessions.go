@@ -0,0 +1,246 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Session is a logged in user, identified by the random key stored in the "admin" cookie.
+type Session struct {
+	Key       string
+	Username  string
+	CSRFToken string
+	Created   time.Time
+	LastSeen  time.Time
+	Messages  []*FlashMessage
+}
+
+// expired reports whether the session has been idle for too long, or is older than maxAge. Zero durations disable
+// the respective check.
+func (s *Session) expired(idleTimeout, maxAge time.Duration) bool {
+	now := time.Now()
+	if idleTimeout > 0 && now.Sub(s.LastSeen) > idleTimeout {
+		return true
+	}
+	if maxAge > 0 && now.Sub(s.Created) > maxAge {
+		return true
+	}
+	return false
+}
+
+func (s *Session) addMessage(class, text string) {
+	s.Messages = append(s.Messages, &FlashMessage{class, text})
+}
+
+func (s *Session) getMessages() []*FlashMessage {
+	// If empty, there's no need to create a new slice.
+	if len(s.Messages) == 0 {
+		return s.Messages
+	}
+
+	messages := s.Messages
+	s.Messages = []*FlashMessage{}
+	return messages
+}
+
+// FlashMessage is a message shown once, on the next page the user visits.
+type FlashMessage struct {
+	Class string
+	Text  string
+}
+
+// SessionStore keeps track of logged in users. Get must return nil (and no error) for unknown or expired sessions.
+type SessionStore interface {
+	Get(key string) (*Session, error)
+	Save(sess *Session) error
+	Delete(key string) error
+}
+
+// Default timeouts used by New.
+const (
+	DefaultIdleTimeout = 2 * time.Hour
+	DefaultMaxAge      = 24 * time.Hour
+)
+
+type memorySessionStore struct {
+	idleTimeout time.Duration
+	maxAge      time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessionStore returns a SessionStore that keeps sessions in memory. Sessions expire when they have been idle
+// for longer than idleTimeout, or are older than maxAge. Zero disables a timeout. All sessions are lost on restart.
+func NewMemorySessionStore(idleTimeout, maxAge time.Duration) SessionStore {
+	return &memorySessionStore{
+		idleTimeout: idleTimeout,
+		maxAge:      maxAge,
+		sessions:    map[string]*Session{},
+	}
+}
+
+func (m *memorySessionStore) Get(key string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ok := m.sessions[key]
+	if !ok {
+		return nil, nil
+	}
+	if sess.expired(m.idleTimeout, m.maxAge) {
+		delete(m.sessions, key)
+		return nil, nil
+	}
+
+	sess.LastSeen = time.Now()
+	return copySession(sess), nil
+}
+
+func (m *memorySessionStore) Save(sess *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Clean up while we're at it, so abandoned sessions don't pile up
+	for key, s := range m.sessions {
+		if s.expired(m.idleTimeout, m.maxAge) {
+			delete(m.sessions, key)
+		}
+	}
+
+	m.sessions[sess.Key] = copySession(sess)
+	return nil
+}
+
+func (m *memorySessionStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, key)
+	return nil
+}
+
+// copySession makes sure callers never share a *Session (or its messages) with the store, or with each other.
+func copySession(sess *Session) *Session {
+	c := *sess
+	c.Messages = make([]*FlashMessage, len(sess.Messages))
+	copy(c.Messages, sess.Messages)
+	return &c
+}
+
+const sessionTable = "admin_session"
+
+type sqlSessionStore struct {
+	admin       *Admin
+	idleTimeout time.Duration
+	maxAge      time.Duration
+}
+
+// SQLSessions returns a SessionStore that keeps sessions in the admin's own database, in a table named admin_session,
+// which is created if it doesn't exist. Sessions survive restarts and can be shared between several instances.
+// Timeouts work like in NewMemorySessionStore.
+func (a *Admin) SQLSessions(idleTimeout, maxAge time.Duration) (SessionStore, error) {
+	q := a.dialect.Queryf(`CREATE TABLE IF NOT EXISTS %v (
+		session_key VARCHAR(64) PRIMARY KEY,
+		username VARCHAR(255) NOT NULL,
+		csrf_token VARCHAR(64) NOT NULL,
+		created BIGINT NOT NULL,
+		last_seen BIGINT NOT NULL,
+		messages TEXT NOT NULL
+	)`, sessionTable)
+	_, err := a.db.Exec(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlSessionStore{a, idleTimeout, maxAge}, nil
+}
+
+func (s *sqlSessionStore) Get(key string) (*Session, error) {
+	q := s.admin.dialect.Queryf("SELECT username, csrf_token, created, last_seen, messages FROM %v WHERE session_key = ?", sessionTable)
+
+	var created, lastSeen int64
+	var messages string
+	sess := &Session{Key: key}
+	err := s.admin.db.QueryRow(q, key).Scan(&sess.Username, &sess.CSRFToken, &created, &lastSeen, &messages)
+	if err != nil {
+		// No rows is not an error, it just means the user isn't logged in
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sess.Created = time.Unix(created, 0)
+	sess.LastSeen = time.Unix(lastSeen, 0)
+
+	if sess.expired(s.idleTimeout, s.maxAge) {
+		return nil, s.Delete(key)
+	}
+
+	err = json.Unmarshal([]byte(messages), &sess.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	sess.LastSeen = time.Now()
+	q = s.admin.dialect.Queryf("UPDATE %v SET last_seen = ? WHERE session_key = ?", sessionTable)
+	_, err = s.admin.db.Exec(q, sess.LastSeen.Unix(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+func (s *sqlSessionStore) Save(sess *Session) error {
+	messages, err := json.Marshal(sess.Messages)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.admin.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Delete and insert instead of an upsert, as the syntax for that differs between databases
+	q := s.admin.dialect.Queryf("DELETE FROM %v WHERE session_key = ?", sessionTable)
+	_, err = tx.Exec(q, sess.Key)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	q = s.admin.dialect.Queryf("INSERT INTO %v (session_key, username, csrf_token, created, last_seen, messages) VALUES (?, ?, ?, ?, ?, ?)", sessionTable)
+	_, err = tx.Exec(q, sess.Key, sess.Username, sess.CSRFToken, sess.Created.Unix(), sess.LastSeen.Unix(), string(messages))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Clean up sessions that have expired
+	if s.idleTimeout > 0 {
+		q = s.admin.dialect.Queryf("DELETE FROM %v WHERE last_seen < ?", sessionTable)
+		_, err = tx.Exec(q, time.Now().Add(-s.idleTimeout).Unix())
+	}
+	if err == nil && s.maxAge > 0 {
+		q = s.admin.dialect.Queryf("DELETE FROM %v WHERE created < ?", sessionTable)
+		_, err = tx.Exec(q, time.Now().Add(-s.maxAge).Unix())
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.New(fmt.Sprintf("Could not clean up expired sessions: %v", err))
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlSessionStore) Delete(key string) error {
+	q := s.admin.dialect.Queryf("DELETE FROM %v WHERE session_key = ?", sessionTable)
+	_, err := s.admin.db.Exec(q, key)
+	return err
+}
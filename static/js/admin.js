@@ -1,19 +1,162 @@
 $(function() {
 	$('input:first').select();
 
-	$('.btn-fk-search').on('click', function() {
+	$(document).on('click', '.btn-fk-search', function() {
 		var prefix = window.location.pathname.split('/')[1];
 		window.open('/' + prefix + '/view/' + $(this).data('slug') + '/popup/', $(this).data('name'),
 			'width=800,toolbar=0,resizable=1,scrollbars=yes,height=600,top=100,left=250');
 	});
 
-	$('.btn-m2m-search').on('click', function() {
+	$(document).on('click', '.btn-m2m-search', function() {
 		var prefix = window.location.pathname.split('/')[1];
 		window.open('/' + prefix + '/view/' + $(this).data('slug') + '/popup/multiselect', $(this).data('name'),
 			'width=800,toolbar=0,resizable=1,scrollbars=yes,height=600,top=100,left=250');
 	});
 
-	$('a.confirm').on('click', function() {
+	// Inline rows are added from a blank template, numbered by the row count
+	$('.inline-add').on('click', function() {
+		var inline = $(this).closest('.inline');
+		var count = inline.find('.inline-count');
+		var row = inline.find('.inline-template').html().replace(/__prefix__/g, count.val());
+		inline.find('.inline-rows').append(row);
+		count.val(parseInt(count.val()) + 1);
+		inline.find('.inline-rows .autocomplete').each(function() { autocompleteLoad($(this)); });
+	});
+
+	// Removed rows are left blank, and skipped when saving
+	$(document).on('click', '.inline-remove', function() {
+		$(this).closest('.inline-row').remove();
+	});
+
+	// Added rows are only saved once something in them has been changed
+	$(document).on('input change', '.inline-row :input', function() {
+		$(this).closest('.inline-row').find('.inline-changed').val('true');
+	});
+
+	$('.select-all').on('change', function() {
+		$(this).closest('table').find('input[name="selected_id"]').prop('checked', $(this).prop('checked'));
+	});
+
+	// Relational fields keep ids in a hidden input, and show the related rows' display values. The admin's path is
+	// set on the body, as it can be mounted anywhere.
+	var autocompleteURL = function(widget) {
+		return $('body').data('path') + '/lookup/' + widget.data('slug') + '/';
+	};
+
+	var autocompleteIds = function(widget) {
+		return $.grep($.map(widget.find('.autocomplete-value').val().split(','), $.trim), function(id) { return id !== ''; });
+	};
+
+	var autocompleteSet = function(widget, ids, texts) {
+		widget.data('texts', $.extend(widget.data('texts') || {}, texts));
+		widget.find('.autocomplete-value').val(ids.join(', '));
+		texts = widget.data('texts');
+
+		if (widget.data('multiple') !== true) {
+			widget.find('.autocomplete-input').val(ids.length > 0 ? (texts[ids[0]] || ids[0]) : '');
+			return;
+		}
+		var selected = widget.find('.autocomplete-selected').empty();
+		$.each(ids, function(i, id) {
+			var label = $('<span class="label label-default">').text((texts[id] || id) + ' ');
+			label.append($('<a href="#" class="autocomplete-remove">&times;</a>').attr('data-id', id));
+			selected.append($('<li>').append(label));
+		});
+	};
+
+	// Display values of the saved ids
+	var autocompleteLoad = function(widget) {
+		var ids = autocompleteIds(widget);
+		if (ids.length === 0) {
+			autocompleteSet(widget, ids, {});
+			return;
+		}
+		$.getJSON(autocompleteURL(widget), {ids: ids.join(','), display: widget.data('display')}, function(data) {
+			var texts = {};
+			$.each(data.results, function(i, row) { texts[row.id] = row.text; });
+			autocompleteSet(widget, ids, texts);
+		});
+	};
+
+	$('.autocomplete').not('.inline-template .autocomplete').each(function() { autocompleteLoad($(this)); });
+
+	var autocompleteTimer;
+	$(document).on('input', '.autocomplete-input', function() {
+		var widget = $(this).closest('.autocomplete');
+		var results = widget.find('.autocomplete-results');
+		var q = $(this).val();
+
+		// Emptying a foreign key's input removes the relation
+		if (widget.data('multiple') !== true && q === '') {
+			autocompleteSet(widget, [], {});
+		}
+
+		clearTimeout(autocompleteTimer);
+		if (q === '') {
+			results.hide();
+			return;
+		}
+		autocompleteTimer = setTimeout(function() {
+			$.getJSON(autocompleteURL(widget), {q: q, display: widget.data('display')}, function(data) {
+				results.empty();
+				$.each(data.results, function(i, row) {
+					var link = $('<a href="#" class="autocomplete-pick">').text(row.text).attr('data-id', row.id).attr('data-text', row.text);
+					results.append($('<li>').append(link));
+				});
+				if (data.results.length === 0) {
+					results.append('<li class="disabled"><a href="#">No matches</a></li>');
+				}
+				results.show();
+			});
+		}, 200);
+	});
+
+	// Search text that wasn't used to pick a row is replaced by the current row's display value again
+	$(document).on('change', '.autocomplete-input', function() {
+		var widget = $(this).closest('.autocomplete');
+		if (widget.data('multiple') !== true && $(this).val() !== '') {
+			autocompleteSet(widget, autocompleteIds(widget), {});
+		}
+	});
+
+	$(document).on('click', '.autocomplete-pick', function() {
+		var widget = $(this).closest('.autocomplete');
+		var id = $(this).attr('data-id');
+		var texts = {};
+		texts[id] = $(this).attr('data-text');
+
+		var ids = [id];
+		if (widget.data('multiple') === true) {
+			ids = autocompleteIds(widget);
+			if ($.inArray(id, ids) === -1) {
+				ids.push(id);
+			}
+			widget.find('.autocomplete-input').val('');
+		}
+		autocompleteSet(widget, ids, texts);
+		widget.find('.autocomplete-results').hide();
+		return false;
+	});
+
+	$(document).on('click', '.autocomplete-remove', function() {
+		var widget = $(this).closest('.autocomplete');
+		var id = $(this).attr('data-id');
+		autocompleteSet(widget, $.grep(autocompleteIds(widget), function(other) { return other !== id; }), {});
+		return false;
+	});
+
+	// The popups change the hidden input directly
+	$(document).on('change', '.autocomplete-value', function() {
+		autocompleteLoad($(this).closest('.autocomplete'));
+	});
+
+	$(document).on('click', function(e) {
+		if ($(e.target).closest('.autocomplete').length === 0) {
+			$('.autocomplete-results').hide();
+		}
+	});
+
+	$('.confirm').on('click', function() {
 		var ok = confirm("Are you sure you want to delete this item?");
 		if(!ok) {
 			return false
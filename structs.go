@@ -0,0 +1,252 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oal/admin/fields"
+)
+
+// Layouts tried when a driver returns times as text
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Get loads the row with the given id (of any type) into a new instance of the registered struct, and returns a pointer
+// to it. Foreign keys and ManyToMany fields are set to structs with only their id set.
+func (m *model) Get(id interface{}) (interface{}, error) {
+	data, err := m.get(m.admin.db, fmt.Sprint(id))
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := m.newStruct(fmt.Sprint(id), data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Interface(), nil
+}
+
+// newStruct returns a pointer to a new instance of the registered struct, set to the row with the given id (if any) and
+// values, from model.get or validated ones. ManyToMany ids can be given separately in m2mData.
+func (m *model) newStruct(id string, data map[string]interface{}, m2mData map[string][]string) (reflect.Value, error) {
+	obj := reflect.New(m.typ)
+	for _, fieldName := range m.fieldNames {
+		val, ok := data[fieldName]
+		if ids, isM2M := m2mData[fieldName]; isM2M {
+			val = ids
+		} else if !ok && fieldName == m.fieldNames[0] && id != "" {
+			val = id
+		}
+		err := m.admin.setStructField(obj.Elem().Field(m.fieldIndex[fieldName]), m.fieldByName(fieldName), val)
+		if err != nil {
+			return obj, errors.New(fmt.Sprintf("%v: %v", fieldName, err))
+		}
+	}
+	return obj, nil
+}
+
+// Save inserts obj, a pointer to the registered struct, or updates the existing row if it has an id. It's validated
+// and saved like the edit form, hooks included, and logged in the audit log as done by username. New rows get their id
+// set on obj.
+func (m *model) Save(obj interface{}, username string) error {
+	val := reflect.ValueOf(obj)
+	if val.Type() != reflect.PtrTo(m.typ) || val.IsNil() {
+		return errors.New(fmt.Sprintf("Expected *%v, got %T.", m.typ.Name(), obj))
+	}
+	val = val.Elem()
+
+	// Zero keys are for new rows, as are keys that aren't in the database yet unless they're generated by it
+	idField := val.Field(m.fieldIndex[m.fieldNames[0]])
+	id := ""
+	if !idField.IsZero() {
+		id = fmt.Sprint(idField.Interface())
+	}
+	if id != "" && !m.autoKey {
+		if _, err := m.get(m.admin.db, id); err == sql.ErrNoRows {
+			id = ""
+		} else if err != nil {
+			return err
+		}
+	}
+
+	form := url.Values{}
+	for _, fieldName := range m.fieldNames[1:] {
+		form.Set(fieldName, m.admin.structFormValue(val.Field(m.fieldIndex[fieldName])))
+	}
+	if id == "" && !m.autoKey {
+		form.Set(m.fieldNames[0], fmt.Sprint(idField.Interface()))
+	}
+
+	newId, _, dataErrors, err := m.save(username, id, &http.Request{Form: form}, nil)
+	if _, ok := err.(noChangesError); ok {
+		return nil
+	}
+	if err != nil {
+		// The form's errors are shown next to each field, so they're the message here
+		msgs := []string{}
+		for _, fieldName := range m.fieldNames {
+			if msg, ok := dataErrors[fieldName]; ok {
+				msgs = append(msgs, fmt.Sprintf("%v: %v", fieldName, msg))
+			}
+		}
+		if len(msgs) > 0 {
+			return errors.New(strings.Join(msgs, " "))
+		}
+		return err
+	}
+
+	return m.admin.setStructField(idField, nil, newId)
+}
+
+// pkIndex returns the index of the primary key of a registered struct type.
+func (a *Admin) pkIndex(t reflect.Type) int {
+	if related, ok := a.registeredRels[reflect.PtrTo(t)]; ok {
+		return related.fieldIndex[related.fieldNames[0]]
+	}
+	return 0
+}
+
+// setStructField sets a struct field to a value from model.get, converting it to the struct field's type.
+func (a *Admin) setStructField(v reflect.Value, field fields.Field, val interface{}) error {
+	if val == nil {
+		return nil
+	}
+	if b, ok := val.([]byte); ok {
+		val = string(b)
+	}
+	if str, ok := val.(string); ok && str == "" && v.Kind() != reflect.String {
+		// Blank fields are stored as empty strings unless they're null
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		// Foreign key, only the related struct's id is known
+		related := reflect.New(v.Type().Elem())
+		err := a.setStructField(related.Elem().Field(a.pkIndex(v.Type().Elem())), nil, val)
+		if err != nil {
+			return err
+		}
+		v.Set(related)
+		return nil
+	case reflect.Slice:
+		ids, ok := val.([]string)
+		if !ok {
+			break
+		}
+		slice := reflect.MakeSlice(v.Type(), 0, len(ids))
+		relatedType := v.Type().Elem().Elem()
+		for _, id := range ids {
+			related := reflect.New(relatedType)
+			err := a.setStructField(related.Elem().Field(a.pkIndex(relatedType)), nil, id)
+			if err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, related)
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Struct:
+		if tm, ok := val.(time.Time); ok {
+			v.Set(reflect.ValueOf(tm))
+			return nil
+		}
+		str, ok := val.(string)
+		if !ok {
+			break
+		}
+		layouts := timeLayouts
+		if timeField, ok := field.(*fields.TimeField); ok {
+			layouts = append([]string{timeField.Format}, layouts...)
+		}
+		for _, layout := range layouts {
+			if tm, err := time.Parse(layout, str); err == nil {
+				v.Set(reflect.ValueOf(tm))
+				return nil
+			}
+		}
+	case reflect.String:
+		v.SetString(fmt.Sprint(val))
+		return nil
+	case reflect.Bool:
+		switch b := val.(type) {
+		case bool:
+			v.SetBool(b)
+			return nil
+		case int64:
+			v.SetBool(b != 0)
+			return nil
+		case string:
+			bl, err := strconv.ParseBool(b)
+			if err != nil {
+				return err
+			}
+			v.SetBool(bl)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(fmt.Sprint(val), 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(fmt.Sprint(val), 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(fmt.Sprint(val), 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Can't convert %T to %v.", val, v.Type()))
+}
+
+// structFormValue converts a struct field to the form value fields.Validate expects. Times are passed as RFC 3339, as
+// the field's format may leave out the time of day.
+func (a *Admin) structFormValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return ""
+		}
+		return fmt.Sprint(v.Elem().Field(a.pkIndex(v.Type().Elem())).Interface())
+	case reflect.Slice:
+		ids := []string{}
+		relatedType := v.Type().Elem().Elem()
+		for i := 0; i < v.Len(); i++ {
+			if related := v.Index(i); !related.IsNil() {
+				ids = append(ids, fmt.Sprint(related.Elem().Field(a.pkIndex(relatedType)).Interface()))
+			}
+		}
+		return strings.Join(ids, ",")
+	}
+
+	if tm, ok := v.Interface().(time.Time); ok {
+		if tm.IsZero() {
+			return ""
+		}
+		return tm.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v.Interface())
+}